@@ -0,0 +1,264 @@
+package e2b
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type (
+	// BuildSpec is the specification of a template build.
+	BuildSpec struct {
+		Dockerfile string          // Dockerfile is the content of the dockerfile; read from ContextDir's Dockerfile if empty.
+		ContextDir string          // ContextDir is the directory of the build context; none if empty.
+		StartCmd   string          // StartCmd is the command run when a sandbox of the template starts.
+		CPU        int             // CPU is the number of vCPUs of the template's sandboxes.
+		Memory     int             // Memory is the memory of the template's sandboxes in MiB.
+		Alias      string          // Alias is the alias of the template.
+		Logs       chan<- BuildLog // Logs receives the build's logs if not nil; it is not closed.
+	}
+
+	// BuildLog is a log line of a template build.
+	BuildLog struct {
+		Timestamp time.Time `json:"timestamp"` // Timestamp of the line.
+		Message   string    `json:"message"`   // Message of the line.
+	}
+
+	// BuildStatus is the status of a template build.
+	BuildStatus string
+
+	// templateBuild is a template build as reported by the control plane.
+	templateBuild struct {
+		TemplateID SandboxTemplate `json:"templateID"`
+		BuildID    string          `json:"buildID"`
+		Status     BuildStatus     `json:"status"`
+		Reason     string          `json:"reason"`
+		Logs       []BuildLog      `json:"logs"`
+	}
+)
+
+const (
+	// BuildStatusBuilding is the status of a running build.
+	BuildStatusBuilding BuildStatus = "building"
+	// BuildStatusReady is the status of a successful build.
+	BuildStatusReady BuildStatus = "ready"
+	// BuildStatusError is the status of a failed build.
+	BuildStatusError BuildStatus = "error"
+
+	buildPollInterval = time.Second
+	defaultDockerfile = "Dockerfile"
+)
+
+// BuildTemplate builds a template from a dockerfile and returns it once
+// ready, to be used with WithTemplate.
+//
+// The build context is uploaded as a gzipped tarball, unless the control
+// plane already has it. The build's logs are sent on the spec's Logs
+// channel while it runs, which must be drained.
+func (c *Client) BuildTemplate(ctx context.Context, spec BuildSpec) (SandboxTemplate, error) {
+	dockerfile := spec.Dockerfile
+	if dockerfile == "" {
+		if spec.ContextDir == "" {
+			return "", fmt.Errorf("no dockerfile: set the spec's Dockerfile or ContextDir")
+		}
+		b, err := os.ReadFile(filepath.Join(spec.ContextDir, defaultDockerfile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read dockerfile: %w", err)
+		}
+		dockerfile = string(b)
+	}
+	body := struct {
+		Alias      string `json:"alias,omitempty"`
+		Dockerfile string `json:"dockerfile"`
+		StartCmd   string `json:"startCmd,omitempty"`
+		CPUCount   int    `json:"cpuCount,omitempty"`
+		MemoryMB   int    `json:"memoryMB,omitempty"`
+	}{
+		Alias:      spec.Alias,
+		Dockerfile: dockerfile,
+		StartCmd:   spec.StartCmd,
+		CPUCount:   spec.CPU,
+		MemoryMB:   spec.Memory,
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s", c.apiURL(), templatesRoute), body)
+	if err != nil {
+		return "", err
+	}
+	var build templateBuild
+	err = c.sendRequest(req, &build)
+	if err != nil {
+		return "", err
+	}
+
+	var hash string
+	if spec.ContextDir != "" {
+		hash, err = c.uploadBuildContext(ctx, &build, spec.ContextDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload build context: %w", err)
+		}
+	}
+	start := struct {
+		ContextHash string `json:"contextHash,omitempty"`
+	}{ContextHash: hash}
+	req, err = c.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s/%s/builds/%s", c.apiURL(), templatesRoute, build.TemplateID, build.BuildID), start)
+	if err != nil {
+		return "", err
+	}
+	err = c.sendRequest(req, nil)
+	if err != nil {
+		return "", err
+	}
+	err = c.waitBuild(ctx, &build, spec.Logs)
+	if err != nil {
+		return "", err
+	}
+	return build.TemplateID, nil
+}
+
+// uploadBuildContext uploads the tarball of the build context unless the
+// control plane already has it, and returns its hash.
+func (c *Client) uploadBuildContext(ctx context.Context, build *templateBuild, dir string) (string, error) {
+	tarball, err := tarDir(dir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(tarball)
+	hash := hex.EncodeToString(sum[:])
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s/files/%s", c.apiURL(), templatesRoute, build.TemplateID, hash), nil)
+	if err != nil {
+		return "", err
+	}
+	var upload struct {
+		Present bool   `json:"present"` // Present is true if the control plane already has the tarball.
+		URL     string `json:"url"`     // URL is the url to upload the tarball to.
+	}
+	err = c.sendRequest(req, &upload)
+	if err != nil {
+		return "", err
+	}
+	if upload.Present {
+		return hash, nil
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, upload.URL, bytes.NewReader(tarball))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	res, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	_ = res.Body.Close()
+	return hash, nil
+}
+
+// waitBuild polls the build's status until it is done, sending its logs on
+// the channel if not nil.
+func (c *Client) waitBuild(ctx context.Context, build *templateBuild, logs chan<- BuildLog) error {
+	offset := 0
+	for {
+		vals := url.Values{"logsOffset": {strconv.Itoa(offset)}}
+		req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s/builds/%s/status?%s", c.apiURL(), templatesRoute, build.TemplateID, build.BuildID, vals.Encode()), nil)
+		if err != nil {
+			return err
+		}
+		var status templateBuild
+		err = c.sendRequest(req, &status)
+		if err != nil {
+			return err
+		}
+		offset += len(status.Logs)
+		for _, line := range status.Logs {
+			if logs == nil {
+				break
+			}
+			select {
+			case logs <- line:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		switch status.Status {
+		case BuildStatusReady:
+			return nil
+		case BuildStatusError:
+			return fmt.Errorf("template %s build %s failed: %s", build.TemplateID, build.BuildID, status.Reason)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(buildPollInterval):
+		}
+	}
+}
+
+// tarDir returns the gzipped tarball of the directory's files, with paths
+// relative to it.
+func tarDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		// Unchanged contexts must hash the same to skip their upload.
+		hdr.ModTime = time.Unix(0, 0)
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		err = tw.WriteHeader(hdr)
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,244 @@
+package e2b
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// Client is a client of the E2B control plane.
+	//
+	// A client holds the configuration shared by the sandboxes it creates
+	// or connects to, which all reuse its http client. It is safe for
+	// concurrent use.
+	Client struct {
+		apiKey   string          // apiKey is the client's api key.
+		baseURL  string          // baseURL is the base api url of the control plane; derived from domain if empty.
+		domain   string          // domain is the domain of the E2B deployment.
+		envdPort int             // envdPort is the port envd listens on in sandboxes.
+		insecure bool            // insecure is true if the deployment is served over plain http.
+		template SandboxTemplate // template is the default template of new sandboxes.
+		timeout  time.Duration   // timeout is the default timeout of new sandboxes.
+		retry    RetryPolicy     // retry is the policy for retrying idempotent requests.
+		limits   *sandboxLimits  // limits caps the sandboxes created by the client; nil if unlimited.
+		err      error           // err is the error of loading the configuration, returned by requests lacking an api key.
+		client   *http.Client    // client is the client's http client.
+		logger   *slog.Logger    // logger is the client's logger.
+	}
+
+	// ClientOption is an option for the client.
+	ClientOption func(*Client)
+)
+
+// NewClient creates a new control plane client.
+//
+// The configuration is resolved as documented on Config: an empty api key
+// falls back to the E2B_API_KEY environment variable or the config file,
+// and the client targets the E2B cloud unless E2B_DOMAIN, the config file
+// or ClientWithDomain points it at a self-hosted deployment.
+//
+// An invalid config file is reported by the client's first request, unless
+// an api key is given explicitly or by the environment.
+func NewClient(apiKey string, opts ...ClientOption) *Client {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		cfg = &Config{}
+		cfg.setEnv()
+	}
+	c := &Client{
+		domain:   defaultDomain,
+		envdPort: defaultEnvdPort,
+		template: defaultTemplate,
+		client:   http.DefaultClient,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		err:      err,
+	}
+	ClientWithConfig(cfg)(c)
+	if apiKey != "" {
+		c.apiKey = apiKey
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Create creates a new sandbox.
+//
+// With ClientWithSandboxLimits, Create waits in fifo order until the
+// sandbox fits in the limits or the context is canceled.
+//
+// When resources are requested with WithCPUCount, WithMemoryMB or
+// WithDiskSizeMB, they are validated against the template first and an
+// ErrResourceOutOfRange is returned if they are not allowed.
+func (c *Client) Create(ctx context.Context, opts ...Option) (*Sandbox, error) {
+	sb := c.newSandbox()
+	for _, opt := range opts {
+		opt(sb)
+	}
+	err := sb.Network.validate()
+	if err != nil {
+		return sb, err
+	}
+	err = sb.validateResources(ctx)
+	if err != nil {
+		return sb, err
+	}
+	err = sb.acquire(ctx)
+	if err != nil {
+		return sb, err
+	}
+	req, err := sb.api.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s", sb.api.apiURL(), sandboxesRoute), sb)
+	if err == nil {
+		err = sb.api.sendRequest(req, sb)
+	}
+	if err != nil {
+		sb.release()
+		return sb, err
+	}
+	err = sb.start(ctx)
+	if err != nil {
+		// Nobody can use the sandbox, nor is expected to stop it.
+		if kerr := c.Kill(context.WithoutCancel(ctx), sb.ID); kerr != nil && !errors.Is(kerr, ErrNotFound) {
+			sb.logger.Error("failed to kill unreachable sandbox", "sandbox", sb.ID, "error", kerr)
+		}
+		sb.release()
+	}
+	return sb, err
+}
+
+// Connect connects to an existing sandbox.
+func (c *Client) Connect(ctx context.Context, sandboxID string, opts ...Option) (*Sandbox, error) {
+	sb := c.newSandbox()
+	sb.ID = sandboxID
+	for _, opt := range opts {
+		opt(sb)
+	}
+	req, err := sb.api.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s", sb.api.apiURL(), sandboxesRoute, sandboxID), nil)
+	if err != nil {
+		return sb, err
+	}
+	err = sb.api.sendRequest(req, sb)
+	if err != nil {
+		return sb, err
+	}
+	return sb, sb.start(ctx)
+}
+
+// Resume resumes a paused sandbox and connects to it.
+//
+// The sandbox's file system, memory and running processes are restored
+// as they were when it was paused. With ClientWithSandboxLimits, Resume
+// waits for the sandbox to fit in the limits like Create.
+func (c *Client) Resume(ctx context.Context, sandboxID string, opts ...Option) (*Sandbox, error) {
+	sb := c.newSandbox()
+	sb.ID = sandboxID
+	for _, opt := range opts {
+		opt(sb)
+	}
+	err := sb.acquire(ctx)
+	if err != nil {
+		return sb, err
+	}
+	body := struct {
+		Timeout int `json:"timeout,omitempty"`
+	}{Timeout: sb.Timeout}
+	req, err := sb.api.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s/%s/resume", sb.api.apiURL(), sandboxesRoute, sandboxID), body)
+	if err == nil {
+		err = sb.api.sendRequest(req, sb)
+	}
+	if err != nil {
+		sb.release()
+		return sb, err
+	}
+	err = sb.start(ctx)
+	if err != nil {
+		// The sandbox keeps its state, but not its slot while unreachable.
+		sb.release()
+	}
+	return sb, err
+}
+
+// Get returns the control plane's information about a sandbox.
+func (c *Client) Get(ctx context.Context, sandboxID string) (*SandboxInfo, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s", c.apiURL(), sandboxesRoute, sandboxID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var info SandboxInfo
+	err = c.sendRequest(req, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Kill kills a sandbox.
+func (c *Client) Kill(ctx context.Context, sandboxID string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, fmt.Sprintf("%s%s%s", c.apiURL(), deleteSandboxRoute, sandboxID), nil)
+	if err != nil {
+		return err
+	}
+	err = c.sendRequest(req, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// newSandbox returns a sandbox of the client with the default options
+// applied.
+func (c *Client) newSandbox() *Sandbox {
+	return &Sandbox{
+		api:      c,
+		Template: c.template,
+		Timeout:  int(c.timeout.Seconds()),
+		Metadata: map[string]string{
+			"sdk": "e2b-go v1",
+		},
+		logger:    c.logger,
+		release:   func() {},
+		transport: DialWebsocket,
+		wsOpts: wsOptions{
+			reconnect:    defaultReconnectPolicy,
+			pingInterval: defaultPingInterval,
+			pongTimeout:  defaultPongTimeout,
+		},
+		Map: new(sync.Map),
+		wsURL: func(s *Sandbox) string {
+			scheme := defaultWSScheme
+			if s.api.insecure {
+				scheme = "ws"
+			}
+			return fmt.Sprintf("%s://%s%s", scheme, s.GetHost(s.api.envdPort), wsRoute)
+		},
+	}
+}
+
+// apiURL returns the base url of the control plane.
+func (c *Client) apiURL() string {
+	if c.baseURL != "" {
+		return c.baseURL
+	}
+	scheme := "https"
+	if c.insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://api.%s", scheme, c.domain)
+}
+
+// with returns a copy of the client modified by fn.
+//
+// Sandbox options changing the control plane configuration use it so that
+// they never modify a client shared with other sandboxes.
+func (c *Client) with(fn func(*Client)) *Client {
+	cp := *c
+	fn(&cp)
+	return &cp
+}
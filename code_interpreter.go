@@ -63,6 +63,7 @@ const (
 
 // NewCodeInterpreter creates a new CodeInterpreter sandbox.
 // By default, it uses the "code-interpreter-v1" template.
+// An empty api key is resolved from the environment or the config file.
 func NewCodeInterpreter(ctx context.Context, apiKey string, opts ...Option) (*CodeInterpreter, error) {
 	// Apply default template if none provided
 	sOpts := append([]Option{WithTemplate(DefaultCodeInterpreterTemplate)}, opts...)
@@ -77,28 +78,20 @@ func NewCodeInterpreter(ctx context.Context, apiKey string, opts ...Option) (*Co
 
 // ExecCell executes a code cell in the notebook environment.
 func (ci *CodeInterpreter) ExecCell(ctx context.Context, code string) (*Execution, error) {
-	respCh := make(chan []byte)
-
 	// notebook_execCell params: [code, kernelID]
 	// kernelID is optional and usually empty for the default kernel
-	err := ci.writeRequest(ctx, notebookExecCell, []any{code}, respCh)
+	msg, err := ci.envd().Call(ctx, notebookExecCell, []any{code})
 	if err != nil {
 		return nil, err
 	}
-
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case body := <-respCh:
-		res, err := decodeResponse[Execution, APIError](body)
-		if err != nil {
-			return nil, err
-		}
-		if res.Error.Code != 0 {
-			return nil, fmt.Errorf("notebook execution failed (%d): %s", res.Error.Code, res.Error.Message)
-		}
-		return &res.Result, nil
+	res, err := decodeResponse[Execution, APIError](msg)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error.Code != 0 {
+		return nil, fmt.Errorf("notebook execution failed (%d): %s", res.Error.Code, res.Error.Message)
 	}
+	return &res.Result, nil
 }
 
 // RunCode is an alias for ExecCell, matching the TS SDK naming.
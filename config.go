@@ -0,0 +1,235 @@
+package e2b
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the sdk configuration shared by the clients of a process.
+//
+// Every setting is resolved from, in increasing order of precedence:
+//
+//  1. the selected profile of the ~/.config/e2b/config.toml file,
+//  2. the E2B_API_KEY and E2B_DOMAIN environment variables,
+//  3. explicit arguments and options, such as NewSandbox's api key or
+//     WithTemplate.
+//
+// The profile is the one named by E2B_PROFILE, or "default". The file
+// holds one table per profile; keys unknown to the sdk are ignored, as the
+// file may be shared with other tools:
+//
+//	[default]
+//	api_key = "e2b_..."
+//	template = "base"
+//	timeout = "10m"
+//
+//	[staging]
+//	api_key = "e2b_..."
+//	domain = "e2b.staging.example.com"
+//	request_timeout = "30s"
+type Config struct {
+	APIKey         string          // APIKey is the api key of the team.
+	Domain         string          // Domain is the domain of the E2B deployment.
+	Template       SandboxTemplate // Template is the default template of new sandboxes.
+	Timeout        time.Duration   // Timeout is the default timeout of new sandboxes.
+	RequestTimeout time.Duration   // RequestTimeout is the timeout of control plane requests.
+}
+
+const (
+	apiKeyEnv      = "E2B_API_KEY"
+	profileEnv     = "E2B_PROFILE"
+	defaultProfile = "default"
+	configFile     = "e2b/config.toml"
+)
+
+// LoadConfig resolves the configuration of the named profile from the
+// config file and the environment; an empty name selects the E2B_PROFILE
+// profile, or "default".
+//
+// A missing config file is not an error, but a missing profile is unless
+// it is the default one.
+func LoadConfig(profile string) (*Config, error) {
+	cfg := &Config{}
+	if profile == "" {
+		profile = os.Getenv(profileEnv)
+	}
+	explicit := profile != ""
+	if !explicit {
+		profile = defaultProfile
+	}
+	path, err := configPath()
+	if err != nil {
+		// Without a home directory there is no config file.
+		path = ""
+	}
+	profiles, err := readConfigFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if explicit {
+			return nil, fmt.Errorf("e2b profile %q not found: %s does not exist", profile, path)
+		}
+		cfg.setEnv()
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values, ok := profiles[profile]
+	if !ok {
+		if explicit {
+			return nil, fmt.Errorf("e2b profile %q not found in %s", profile, path)
+		}
+		cfg.setEnv()
+		return cfg, nil
+	}
+	err = cfg.set(values)
+	if err != nil {
+		return nil, fmt.Errorf("e2b profile %q in %s: %w", profile, path, err)
+	}
+	cfg.setEnv()
+	return cfg, nil
+}
+
+// setEnv sets the configuration from the environment variables that are
+// set.
+func (c *Config) setEnv() {
+	if v := os.Getenv(apiKeyEnv); v != "" {
+		c.APIKey = v
+	}
+	if v := os.Getenv(domainEnv); v != "" {
+		c.Domain = v
+	}
+}
+
+// set sets the configuration from the key/values of a profile.
+func (c *Config) set(values map[string]string) (err error) {
+	for k, v := range values {
+		switch k {
+		case "api_key":
+			c.APIKey = v
+		case "domain":
+			c.Domain = v
+		case "template":
+			c.Template = SandboxTemplate(v)
+		case "timeout":
+			c.Timeout, err = parseConfigDuration(v)
+		case "request_timeout":
+			c.RequestTimeout, err = parseConfigDuration(v)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// configPath returns the path of the config file.
+func configPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, configFile), nil
+}
+
+// readConfigFile reads the profiles of the config file.
+//
+// Only the subset of toml used by the config file is supported: tables of
+// string, integer and boolean keys, and comments.
+func readConfigFile(path string) (map[string]map[string]string, error) {
+	if path == "" {
+		return nil, fs.ErrNotExist
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	profiles := map[string]map[string]string{}
+	var values map[string]string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if strings.HasPrefix(name, `"`) {
+				name, err = strconv.Unquote(name)
+			}
+			if err != nil || name == "" {
+				return nil, fmt.Errorf("%s:%d: invalid table %s", path, n, line)
+			}
+			values = map[string]string{}
+			profiles[name] = values
+		default:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok || values == nil {
+				return nil, fmt.Errorf("%s:%d: expected key = value in a [profile] table", path, n)
+			}
+			v, err := unquote(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			values[strings.TrimSpace(k)] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// stripComment removes a trailing comment outside of quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote returns the value of a toml string, integer or boolean.
+func unquote(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		return strconv.Unquote(v)
+	case strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") && len(v) >= 2:
+		return v[1 : len(v)-1], nil
+	case v == "true" || v == "false":
+		return v, nil
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", v)
+	}
+	return v, nil
+}
+
+// parseConfigDuration parses a duration such as "10m", or a number of
+// seconds.
+func parseConfigDuration(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
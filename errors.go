@@ -1,8 +1,51 @@
 package e2b
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is matched by errors for resources, such as sandboxes,
+	// that do not exist or no longer exist.
+	ErrNotFound = errors.New("e2b: not found")
+	// ErrUnauthorized is matched by errors for missing or invalid api keys.
+	ErrUnauthorized = errors.New("e2b: unauthorized")
+	// ErrRateLimited is matched by errors for requests rejected because
+	// too many were sent.
+	ErrRateLimited = errors.New("e2b: rate limited")
+	// ErrQuotaExceeded is matched by errors for requests rejected because
+	// the team's quota, such as its concurrent sandboxes, is exhausted.
+	ErrQuotaExceeded = errors.New("e2b: quota exceeded")
+	// ErrConnectionLost is matched by errors for envd calls that failed
+	// because the sandbox's websocket was lost; the call may or may not
+	// have been executed.
+	ErrConnectionLost = errors.New("e2b: sandbox connection lost")
+	// ErrNotConnected is matched by errors for envd calls of a sandbox
+	// whose transport was never dialed, such as one whose creation failed.
+	ErrNotConnected = errors.New("e2b: sandbox not connected")
+)
 
 type (
+	// ControlPlaneError is returned when the control plane rejects a
+	// request.
+	//
+	// It matches ErrNotFound, ErrUnauthorized, ErrRateLimited and
+	// ErrQuotaExceeded with errors.Is according to its status code.
+	ControlPlaneError struct {
+		Method     string        // Method of the request.
+		Path       string        // Path of the request.
+		StatusCode int           // StatusCode of the response.
+		Code       int           // Code is the error code of the response's body.
+		Message    string        // Message is the error message of the response's body.
+		RequestID  string        // RequestID identifies the request to E2B support.
+		RetryAfter time.Duration // RetryAfter is the wait requested by the control plane, if any.
+	}
 	// ErrToolNotFound is returned when a tool is not found.
 	ErrToolNotFound struct {
 		ToolName string
@@ -17,6 +60,19 @@ type (
 		ToolName string
 		ArgName  string
 	}
+	// ErrResourceOutOfRange is returned when a requested sandbox resource is
+	// negative or exceeds the maximum allowed by the sandbox's template.
+	ErrResourceOutOfRange struct {
+		Template SandboxTemplate
+		Resource string
+		Value    int
+		Max      int // Max is zero if the template does not report it.
+	}
+	// ErrInvalidEgressRule is returned when an egress allowlist entry is
+	// neither a domain, an IP nor a CIDR.
+	ErrInvalidEgressRule struct {
+		Rule string
+	}
 )
 
 // Error implements the error interface for ErrToolNotFound.
@@ -33,3 +89,78 @@ func (e ErrToolArgument) Error() string {
 func (e ErrMissingRequiredArgument) Error() string {
 	return fmt.Sprintf("missing required argument %s for tool %s", e.ArgName, e.ToolName)
 }
+
+// Error implements the error interface for ErrResourceOutOfRange.
+func (e ErrResourceOutOfRange) Error() string {
+	if e.Max <= 0 {
+		return fmt.Sprintf("%s %d is negative", e.Resource, e.Value)
+	}
+	return fmt.Sprintf(
+		"%s %d out of range (0, %d] for template %s",
+		e.Resource, e.Value, e.Max, e.Template,
+	)
+}
+
+// Error implements the error interface for ErrInvalidEgressRule.
+func (e ErrInvalidEgressRule) Error() string {
+	return fmt.Sprintf("invalid egress rule %q: want a domain, an IP or a CIDR", e.Rule)
+}
+
+const requestIDHeader = "X-Request-Id"
+
+// newControlPlaneError returns the error of a rejected request.
+func newControlPlaneError(req *http.Request, res *http.Response) *ControlPlaneError {
+	e := &ControlPlaneError{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		StatusCode: res.StatusCode,
+		RequestID:  res.Header.Get(requestIDHeader),
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	var apiErr APIError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+		e.Code = apiErr.Code
+		e.Message = apiErr.Message
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// Error implements the error interface for ControlPlaneError.
+func (e *ControlPlaneError) Error() string {
+	msg := fmt.Sprintf("e2b: %s %s failed: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request id " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is reports whether the error matches one of the control plane sentinel
+// errors.
+func (e *ControlPlaneError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized ||
+			e.StatusCode == http.StatusForbidden
+	case ErrQuotaExceeded:
+		return e.StatusCode == http.StatusPaymentRequired ||
+			e.StatusCode == http.StatusTooManyRequests && e.quota()
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests && !e.quota()
+	}
+	return false
+}
+
+// quota reports whether a rejection is due to the team's quota rather than
+// its request rate.
+func (e *ControlPlaneError) quota() bool {
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "quota") || strings.Contains(msg, "concurrent")
+}
@@ -5,6 +5,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
 )
 
 type (
@@ -52,45 +57,32 @@ const (
 
 // Mkdir makes a directory in the sandbox file system.
 func (s *Sandbox) Mkdir(ctx context.Context, path string) error {
-	respCh := make(chan []byte)
-	err := s.writeRequest(ctx, filesystemMakeDir, []any{path}, respCh)
+	msg, err := s.envd().Call(ctx, filesystemMakeDir, []any{path})
 	if err != nil {
 		return err
 	}
-	select {
-	case body := <-respCh:
-		resp, err := decodeResponse[string, APIError](body)
-		if err != nil {
-			return fmt.Errorf("failed to mkdir: %w", err)
-		}
-		if resp.Error.Code != 0 {
-			return fmt.Errorf("failed to write to file: %s", resp.Error.Message)
-		}
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+	resp, err := decodeResponse[string, APIError](msg)
+	if err != nil {
+		return fmt.Errorf("failed to mkdir: %w", err)
 	}
+	if resp.Error.Code != 0 {
+		return fmt.Errorf("failed to write to file: %s", resp.Error.Message)
+	}
+	return nil
 }
 
 // Ls lists the files and/or directories in the sandbox file system at
 // the given path.
 func (s *Sandbox) Ls(ctx context.Context, path string) ([]LsResult, error) {
-	respCh := make(chan []byte)
-	defer close(respCh)
-	err := s.writeRequest(ctx, filesystemList, []any{path}, respCh)
+	msg, err := s.envd().Call(ctx, filesystemList, []any{path})
 	if err != nil {
 		return nil, err
 	}
-	select {
-	case body := <-respCh:
-		res, err := decodeResponse[[]LsResult, string](body)
-		if err != nil {
-			return nil, err
-		}
-		return res.Result, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	res, err := decodeResponse[[]LsResult, string](msg)
+	if err != nil {
+		return nil, err
 	}
+	return res.Result, nil
 }
 
 // Read reads a file from the sandbox file system.
@@ -98,131 +90,192 @@ func (s *Sandbox) Read(
 	ctx context.Context,
 	path string,
 ) (string, error) {
-	respCh := make(chan []byte)
-	err := s.writeRequest(ctx, filesystemRead, []any{path}, respCh)
+	msg, err := s.envd().Call(ctx, filesystemRead, []any{path})
 	if err != nil {
 		return "", err
 	}
-	select {
-	case <-ctx.Done():
-		return "", ctx.Err()
-	case body := <-respCh:
-		res, err := decodeResponse[string, string](body)
-		if err != nil {
-			return "", err
-		}
-		if res.Error != "" {
-			return "", fmt.Errorf("failed to read file: %s", res.Error)
-		}
-		return res.Result, nil
+	res, err := decodeResponse[string, string](msg)
+	if err != nil {
+		return "", err
+	}
+	if res.Error != "" {
+		return "", fmt.Errorf("failed to read file: %s", res.Error)
 	}
+	return res.Result, nil
 }
 
 // Write writes to a file to the sandbox file system.
 func (s *Sandbox) Write(ctx context.Context, path string, data []byte) error {
-	respCh := make(chan []byte)
-	err := s.writeRequest(ctx, filesystemWrite, []any{path, string(data)}, respCh)
-	if err != nil {
-		return err
-	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case resp := <-respCh:
-		err = json.Unmarshal(resp, &Request{})
-		if err != nil {
-			return err
-		}
-		return nil
-	}
+	_, err := s.envd().Call(ctx, filesystemWrite, []any{path, string(data)})
+	return err
 }
 
 // WriteBytes writes bytes to a file in the sandbox file system.
 func (s *Sandbox) WriteBytes(ctx context.Context, path string, data []byte) error {
-	respCh := make(chan []byte)
 	sEnc := base64.StdEncoding.EncodeToString(data)
-	err := s.writeRequest(ctx, filesystemWriteBytes, []any{path, sEnc}, respCh)
-	if err != nil {
-		return err
-	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case resp := <-respCh:
-		err = json.Unmarshal(resp, &Request{})
-		if err != nil {
-			return err
-		}
-		return nil
-	}
+	_, err := s.envd().Call(ctx, filesystemWriteBytes, []any{path, sEnc})
+	return err
 }
 
 // ReadBytes reads a file from the sandbox file system.
 func (s *Sandbox) ReadBytes(ctx context.Context, path string) ([]byte, error) {
-	resCh := make(chan []byte)
-	defer close(resCh)
-	err := s.writeRequest(ctx, filesystemReadBytes, []any{path}, resCh)
+	msg, err := s.envd().Call(ctx, filesystemReadBytes, []any{path})
 	if err != nil {
 		return nil, err
 	}
-	select {
-	case body := <-resCh:
-		res, err := decodeResponse[string, string](body)
-		if err != nil {
-			return nil, err
-		}
-		sDec, err := base64.StdEncoding.DecodeString(res.Result)
-		if err != nil {
-			return nil, err
-		}
-		return sDec, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	res, err := decodeResponse[string, string](msg)
+	if err != nil {
+		return nil, err
 	}
+	sDec, err := base64.StdEncoding.DecodeString(res.Result)
+	if err != nil {
+		return nil, err
+	}
+	return sDec, nil
 }
 
 // Watch watches a directory in the sandbox file system.
 //
-// This is intended to be run in a goroutine as it will block until the
-// connection is closed, an error occurs, or the context is canceled.
-//
-// While blocking, filesystem events will be written to the provided channel.
+// Once subscribed, filesystem events are written to the provided channel
+// in a goroutine until the connection is closed, an error occurs, or the
+// context is canceled. A connection lost for good is reported by the
+// sandbox's Done and Err.
 func (s *Sandbox) Watch(
 	ctx context.Context,
 	path string,
 	eCh chan<- Event,
 ) error {
-	respCh := make(chan []byte)
-	defer close(respCh)
-	err := s.writeRequest(ctx, filesystemSubscribe, []any{"watchDir", path}, respCh)
+	sub, err := s.envd().Subscribe(ctx, filesystemSubscribe, []any{"watchDir", path})
 	if err != nil {
 		return err
 	}
-	res, err := decodeResponse[string, string](<-respCh)
-	if err != nil {
-		return err
-	}
-	s.Map.Store(res.Result, eCh)
 	go func() {
+		defer sub.Close()
 		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			event, err := newEvent(msg)
+			if err != nil || event.Error != "" {
+				return
+			}
 			select {
+			case eCh <- event:
 			case <-ctx.Done():
 				return
-			default:
-				var event Event
-				err := json.Unmarshal(<-respCh, &event)
-				if err != nil {
-					return
-				}
-				if event.Error != "" {
-					return
-				}
-				if event.Params.Subscription != path {
-					continue
-				}
-				eCh <- event
 			}
 		}
 	}()
 	return nil
 }
+
+// newEvent decodes the event of a subscription's notification.
+func newEvent(msg *Message) (Event, error) {
+	var event Event
+	event.Params.Subscription = msg.Params.Subscription
+	if len(msg.Params.Result) == 0 {
+		return event, nil
+	}
+	// File system events carry their path and name along the result.
+	var result struct {
+		EventResult
+		Path string `json:"path"`
+		Name string `json:"name"`
+	}
+	err := json.Unmarshal(msg.Params.Result, &result)
+	if err != nil {
+		return event, err
+	}
+	event.Params.Result = result.EventResult
+	event.Path = result.Path
+	event.Name = result.Name
+	event.Timestamp = result.Timestamp
+	event.Error = result.Error
+	return event, nil
+}
+
+// UploadFile uploads a file to the sandbox file system over http.
+//
+// Unlike Write, the content is streamed, which suits large files.
+func (s *Sandbox) UploadFile(ctx context.Context, path string, r io.Reader) error {
+	u, err := s.fileURL(path)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, pr)
+	if err != nil {
+		_ = pr.Close()
+		return err
+	}
+	req.Header = s.envdHeader()
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := s.api.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < http.StatusOK ||
+		resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("request to upload file failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// DownloadFile downloads a file from the sandbox file system over http.
+//
+// Unlike ReadBytes, the content is streamed; the caller must close the
+// returned reader.
+func (s *Sandbox) DownloadFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	u, err := s.fileURL(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.envdHeader()
+	resp, err := s.api.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusOK ||
+		resp.StatusCode >= http.StatusBadRequest {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("request to download file failed: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fileURL returns the envd url transferring the file at path.
+//
+// envd serves files on the host of its websocket.
+func (s *Sandbox) fileURL(path string) (string, error) {
+	u, err := url.Parse(s.wsURL(s))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "ws" {
+		u.Scheme = "http"
+	} else {
+		u.Scheme = "https"
+	}
+	u.Path = fileRoute
+	u.RawQuery = url.Values{"path": {path}}.Encode()
+	return u.String(), nil
+}
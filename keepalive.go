@@ -0,0 +1,88 @@
+package e2b
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// KeepAliveFailureFunc is called when the automatic keep-alive can no
+	// longer refresh the sandbox.
+	KeepAliveFailureFunc func(s *Sandbox, err error)
+
+	// keepAlive is the automatic keep-alive configuration of a sandbox.
+	keepAlive struct {
+		interval  time.Duration        // interval between refreshes.
+		extension time.Duration        // extension is the timeout set on every refresh.
+		onFailure KeepAliveFailureFunc // onFailure is called when refreshing gives up.
+		cancel    context.CancelFunc   // cancel stops the keep-alive goroutine.
+	}
+)
+
+const keepAliveMinBackoff = time.Second
+
+// startKeepAlive starts the automatic keep-alive goroutine if configured.
+func (s *Sandbox) startKeepAlive() {
+	if s.keepAlive.interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.keepAlive.cancel = cancel
+	s.mu.Unlock()
+	go s.runKeepAlive(ctx)
+}
+
+// stopKeepAlive stops the automatic keep-alive goroutine, if running.
+func (s *Sandbox) stopKeepAlive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keepAlive.cancel != nil {
+		s.keepAlive.cancel()
+		s.keepAlive.cancel = nil
+	}
+}
+
+// runKeepAlive refreshes the sandbox every interval until the context is
+// canceled.
+//
+// Failed refreshes are retried with exponential backoff. Once the sandbox
+// is gone or the next retry would land after it expires, the failure
+// callback is called and the goroutine gives up.
+func (s *Sandbox) runKeepAlive(ctx context.Context) {
+	wait := s.keepAlive.interval
+	backoff := keepAliveMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		err := s.KeepAlive(ctx, s.keepAlive.extension)
+		if err == nil {
+			wait = s.keepAlive.interval
+			backoff = keepAliveMinBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("failed to keep sandbox alive",
+			"sandbox", s.ID,
+			"retry", backoff,
+			"error", err,
+		)
+		if errors.Is(err, ErrNotFound) ||
+			!time.Now().Add(backoff).Before(s.ExpiresAt()) {
+			s.logger.Error("giving up keeping sandbox alive", "sandbox", s.ID, "error", err)
+			s.release()
+			if s.keepAlive.onFailure != nil {
+				s.keepAlive.onFailure(s, err)
+			}
+			return
+		}
+		wait = backoff
+		backoff = min(backoff*2, s.keepAlive.interval)
+	}
+}
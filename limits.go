@@ -0,0 +1,201 @@
+package e2b
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// sandboxLimits caps the sandboxes created by the clients sharing it.
+	sandboxLimits struct {
+		running *semaphore   // running caps the concurrent sandboxes; nil if unlimited.
+		creates *rateLimiter // creates caps the creations per second; nil if unlimited.
+	}
+
+	// semaphore is a counting semaphore granting slots in fifo order.
+	semaphore struct {
+		mu      sync.Mutex
+		size    int        // size is the number of slots.
+		used    int        // used is the number of granted slots.
+		waiters *list.List // waiters are the channels of the queued callers.
+	}
+
+	// rateLimiter spaces events at a fixed interval, in the order they are
+	// reserved.
+	rateLimiter struct {
+		mu       sync.Mutex
+		interval time.Duration // interval between events.
+		next     time.Time     // next is the earliest time of the next event.
+		freed    []time.Time   // freed are the times given back by canceled waiters, earliest first.
+	}
+)
+
+// acquire waits for a creation to be allowed and returns the function
+// releasing its concurrent sandbox slot.
+func (l *sandboxLimits) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release := func() {}
+	if l.running != nil {
+		err := l.running.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		release = sync.OnceFunc(l.running.release)
+	}
+	if l.creates != nil {
+		err := l.creates.wait(ctx)
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// acquire waits for the sandbox to fit in its client's limits and sets the
+// function releasing its slot.
+func (s *Sandbox) acquire(ctx context.Context) error {
+	release, err := s.api.limits.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	s.release = release
+	return nil
+}
+
+// releaseOnExpiry releases the sandbox's concurrent slot once the sandbox
+// expires on the control plane, so that a sandbox left to expire does not
+// keep it. KeepAlive and SetTimeout push the release back.
+func (s *Sandbox) releaseOnExpiry() {
+	if s.api.limits == nil || s.api.limits.running == nil {
+		return
+	}
+	release := s.release
+	s.mu.Lock()
+	expiry := time.AfterFunc(time.Until(s.expiresAt), release)
+	s.expiry = expiry
+	s.mu.Unlock()
+	s.release = func() {
+		expiry.Stop()
+		release()
+	}
+}
+
+func newSemaphore(size int) *semaphore {
+	return &semaphore{size: size, waiters: list.New()}
+}
+
+// acquire waits for a slot, in fifo order, or for the context to be
+// canceled.
+func (s *semaphore) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.used < s.size && s.waiters.Len() == 0 {
+		s.used++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-ready:
+			// The slot was granted while giving up; hand it on.
+			s.releaseLocked()
+		default:
+			s.waiters.Remove(elem)
+		}
+		return ctx.Err()
+	}
+}
+
+// release releases a slot, handing it to the first waiter if any.
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *semaphore) releaseLocked() {
+	front := s.waiters.Front()
+	if front == nil {
+		s.used--
+		return
+	}
+	s.waiters.Remove(front)
+	close(front.Value.(chan struct{}))
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait reserves the next event and waits for its time or for the context
+// to be canceled.
+//
+// A canceled waiter gives its time back, so that it does not delay the
+// events reserved after it.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	at := r.reserve()
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.giveBack(at)
+		return ctx.Err()
+	}
+}
+
+// reserve reserves the time of an event: the earliest time given back if
+// still to come, or the next one.
+func (r *rateLimiter) reserve() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for len(r.freed) > 0 {
+		at := r.freed[0]
+		r.freed = r.freed[1:]
+		if at.After(now) {
+			return at
+		}
+	}
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	return at
+}
+
+// giveBack gives the time of a canceled event back.
+func (r *rateLimiter) giveBack(at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next.Equal(at.Add(r.interval)) {
+		// The last reservation: the next event can take its place.
+		r.next = at
+		return
+	}
+	i, _ := slices.BinarySearchFunc(r.freed, at, time.Time.Compare)
+	r.freed = slices.Insert(r.freed, i, at)
+}
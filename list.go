@@ -0,0 +1,136 @@
+package e2b
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// SandboxState is the lifecycle state of a sandbox.
+	SandboxState string
+
+	// SandboxInfo is a sandbox as reported by the control plane.
+	SandboxInfo struct {
+		ID         string            `json:"sandboxID"`  // ID of the sandbox.
+		ClientID   string            `json:"clientID"`   // ClientID of the sandbox.
+		Template   SandboxTemplate   `json:"templateID"` // Template of the sandbox.
+		Alias      string            `json:"alias"`      // Alias of the sandbox's template.
+		Metadata   map[string]string `json:"metadata"`   // Metadata of the sandbox.
+		StartedAt  time.Time         `json:"startedAt"`  // StartedAt is when the sandbox was started.
+		EndAt      time.Time         `json:"endAt"`      // EndAt is when the sandbox will be killed.
+		CPUCount   int               `json:"cpuCount"`   // CPUCount is the number of vCPUs of the sandbox.
+		MemoryMB   int               `json:"memoryMB"`   // MemoryMB is the memory of the sandbox in MiB.
+		DiskSizeMB int               `json:"diskSizeMB"` // DiskSizeMB is the disk size of the sandbox in MiB.
+		State      SandboxState      `json:"state"`      // State of the sandbox.
+	}
+
+	// SandboxPage is a page of sandboxes returned by ListSandboxes.
+	SandboxPage struct {
+		Sandboxes []SandboxInfo // Sandboxes of the page.
+		NextToken string        // NextToken fetches the next page; empty on the last page.
+	}
+
+	// ListOption is an option for listing sandboxes.
+	ListOption func(*listQuery)
+
+	// listQuery is the query of a list request.
+	listQuery struct {
+		metadata  map[string]string
+		template  SandboxTemplate
+		states    []SandboxState
+		limit     int
+		nextToken string
+	}
+)
+
+const (
+	// SandboxStateRunning is the state of a running sandbox.
+	SandboxStateRunning SandboxState = "running"
+	// SandboxStatePaused is the state of a paused sandbox.
+	SandboxStatePaused SandboxState = "paused"
+
+	nextTokenHeader = "X-Next-Token"
+)
+
+// ListSandboxes lists the sandboxes of the team owning the api key.
+//
+// It is a shorthand for listing with a new Client; use Client.List to
+// reach the control plane with client options. Only a single page is
+// returned; pass the page's NextToken back with ListWithNextToken to fetch
+// the following one.
+func ListSandboxes(
+	ctx context.Context,
+	apiKey string,
+	opts ...ListOption,
+) (*SandboxPage, error) {
+	return NewClient(apiKey).List(ctx, opts...)
+}
+
+// List lists the sandboxes of the team owning the client's api key.
+//
+// Only a single page is returned; pass the page's NextToken back with
+// ListWithNextToken to fetch the following one.
+func (c *Client) List(ctx context.Context, opts ...ListOption) (*SandboxPage, error) {
+	q := listQuery{}
+	for _, opt := range opts {
+		opt(&q)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s?%s", c.apiURL(), sandboxesRoute, q.encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	page := SandboxPage{NextToken: resp.Header.Get(nextTokenHeader)}
+	err = json.NewDecoder(resp.Body).Decode(&page.Sandboxes)
+	if err != nil {
+		return nil, err
+	}
+	if q.template != "" {
+		// The control plane filters by metadata and state only.
+		filtered := page.Sandboxes[:0]
+		for _, info := range page.Sandboxes {
+			if info.Template == q.template || SandboxTemplate(info.Alias) == q.template {
+				filtered = append(filtered, info)
+			}
+		}
+		page.Sandboxes = filtered
+	}
+	return &page, nil
+}
+
+func (q *listQuery) encode() string {
+	vals := url.Values{}
+	if len(q.metadata) > 0 {
+		md := url.Values{}
+		for k, v := range q.metadata {
+			md.Set(k, v)
+		}
+		vals.Set("metadata", md.Encode())
+	}
+	if len(q.states) > 0 {
+		states := make([]string, len(q.states))
+		for i, state := range q.states {
+			states[i] = string(state)
+		}
+		vals.Set("state", strings.Join(states, ","))
+	}
+	if q.limit > 0 {
+		vals.Set("limit", strconv.Itoa(q.limit))
+	}
+	if q.nextToken != "" {
+		vals.Set("nextToken", q.nextToken)
+	}
+	return vals.Encode()
+}
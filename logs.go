@@ -0,0 +1,109 @@
+package e2b
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type (
+	// LogEntry is a log entry of a sandbox.
+	LogEntry struct {
+		Timestamp time.Time         `json:"timestamp"` // Timestamp of the entry.
+		Level     string            `json:"level"`     // Level of the entry, e.g. "info" or "error".
+		Message   string            `json:"message"`   // Message of the entry.
+		Fields    map[string]string `json:"fields"`    // Fields are the structured fields of the entry.
+	}
+
+	// LogsOptions are the options for reading a sandbox's logs.
+	LogsOptions struct {
+		Since  time.Time // Since only returns entries logged at or after this time.
+		Limit  int       // Limit caps the number of entries fetched per request.
+		Follow bool      // Follow keeps streaming new entries until the context is canceled.
+	}
+)
+
+const logsFollowInterval = time.Second
+
+// Logs reads the sandbox's logs, oldest first, on the returned channel.
+//
+// Without Follow, the channels are closed once the current logs have been
+// sent or fetching them failed. With Follow, the logs are polled until the
+// context is canceled and failed polls are reported on the error channel
+// without stopping; an error is dropped while the previous one is unread.
+func (s *Sandbox) Logs(ctx context.Context, opts LogsOptions) (<-chan LogEntry, <-chan error) {
+	entries := make(chan LogEntry)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		defer close(errs)
+		since := opts.Since
+		seen := 0 // entries already sent logged exactly at since
+		for {
+			logs, err := s.fetchLogs(ctx, since, opts.Limit)
+			if err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+				if !opts.Follow {
+					return
+				}
+			}
+			skip := seen
+			for _, entry := range logs {
+				if entry.Timestamp.Before(since) {
+					continue
+				}
+				if entry.Timestamp.Equal(since) && skip > 0 {
+					skip--
+					continue
+				}
+				if entry.Timestamp.Equal(since) {
+					seen++
+				} else {
+					since, seen = entry.Timestamp, 1
+				}
+				select {
+				case entries <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !opts.Follow {
+				return
+			}
+			select {
+			case <-time.After(logsFollowInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return entries, errs
+}
+
+func (s *Sandbox) fetchLogs(ctx context.Context, since time.Time, limit int) ([]LogEntry, error) {
+	vals := url.Values{}
+	if !since.IsZero() {
+		vals.Set("start", strconv.FormatInt(since.UnixMilli(), 10))
+	}
+	if limit > 0 {
+		vals.Set("limit", strconv.Itoa(limit))
+	}
+	req, err := s.api.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s/logs?%s", s.api.apiURL(), sandboxesRoute, s.ID, vals.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		LogEntries []LogEntry `json:"logEntries"`
+	}
+	err = s.api.sendRequest(req, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.LogEntries, nil
+}
@@ -0,0 +1,104 @@
+package e2b
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SandboxMetrics is a resource usage sample of a sandbox.
+type SandboxMetrics struct {
+	Timestamp  time.Time `json:"timestamp"`  // Timestamp of the sample.
+	CPUCount   int       `json:"cpuCount"`   // CPUCount is the number of vCPUs of the sandbox.
+	CPUUsedPct float64   `json:"cpuUsedPct"` // CPUUsedPct is the cpu usage in percent.
+	MemUsed    int64     `json:"memUsed"`    // MemUsed is the used memory in bytes.
+	MemTotal   int64     `json:"memTotal"`   // MemTotal is the total memory in bytes.
+	DiskUsed   int64     `json:"diskUsed"`   // DiskUsed is the used disk space in bytes.
+	DiskTotal  int64     `json:"diskTotal"`  // DiskTotal is the total disk space in bytes.
+}
+
+// MemUsedPct returns the memory usage in percent.
+func (m *SandboxMetrics) MemUsedPct() float64 {
+	if m.MemTotal == 0 {
+		return 0
+	}
+	return float64(m.MemUsed) / float64(m.MemTotal) * 100
+}
+
+// DiskUsedPct returns the disk usage in percent.
+func (m *SandboxMetrics) DiskUsedPct() float64 {
+	if m.DiskTotal == 0 {
+		return 0
+	}
+	return float64(m.DiskUsed) / float64(m.DiskTotal) * 100
+}
+
+// defaultMetricsInterval is the interval between metrics polls when none
+// is given.
+const defaultMetricsInterval = 5 * time.Second
+
+// Metrics returns the resource usage samples of the sandbox, oldest first.
+func (s *Sandbox) Metrics(ctx context.Context) ([]SandboxMetrics, error) {
+	req, err := s.api.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s/metrics", s.api.apiURL(), sandboxesRoute, s.ID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []SandboxMetrics
+	err = s.api.sendRequest(req, &metrics)
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// WatchMetrics polls the sandbox's resource usage every interval and
+// streams new samples on the returned channel; a non-positive interval
+// polls every 5 seconds.
+//
+// Failed polls are reported on the error channel without stopping the
+// watch; an error is dropped while the previous one is unread. Both
+// channels are closed once the context is canceled.
+func (s *Sandbox) WatchMetrics(
+	ctx context.Context,
+	interval time.Duration,
+) (<-chan SandboxMetrics, <-chan error) {
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+	samples := make(chan SandboxMetrics)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(samples)
+		defer close(errs)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last time.Time
+		for {
+			metrics, err := s.Metrics(ctx)
+			if err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+			for _, m := range metrics {
+				if !m.Timestamp.After(last) {
+					continue
+				}
+				last = m.Timestamp
+				select {
+				case samples <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return samples, errs
+}
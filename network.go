@@ -0,0 +1,68 @@
+package e2b
+
+import (
+	"net"
+	"strings"
+)
+
+// NetworkPolicy is the network egress policy of a sandbox.
+type NetworkPolicy struct {
+	// AllowInternetAccess is false if the sandbox cannot reach the
+	// internet; nil leaves the template's default.
+	AllowInternetAccess *bool `json:"allowInternetAccess,omitempty"`
+	// AllowOut are the only domains, IPs and CIDRs the sandbox can reach.
+	// When empty, egress is governed by AllowInternetAccess alone.
+	AllowOut []string `json:"allowOut,omitempty"`
+}
+
+// InternetAccess reports whether the sandbox can reach arbitrary internet
+// hosts.
+func (p *NetworkPolicy) InternetAccess() bool {
+	if p == nil {
+		return true
+	}
+	if len(p.AllowOut) > 0 {
+		return false
+	}
+	return p.AllowInternetAccess == nil || *p.AllowInternetAccess
+}
+
+// validate checks that every egress rule is a domain, an IP or a CIDR.
+func (p *NetworkPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, rule := range p.AllowOut {
+		if !validEgressRule(rule) {
+			return ErrInvalidEgressRule{Rule: rule}
+		}
+	}
+	return nil
+}
+
+// validEgressRule reports whether the rule is a domain, optionally with a
+// leading "*." wildcard, an IP or a CIDR.
+func validEgressRule(rule string) bool {
+	if _, _, err := net.ParseCIDR(rule); err == nil {
+		return true
+	}
+	if net.ParseIP(rule) != nil {
+		return true
+	}
+	domain := strings.TrimPrefix(rule, "*.")
+	if len(domain) == 0 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 ||
+			label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
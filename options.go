@@ -1,25 +1,151 @@
 package e2b
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
+// E2B Client Options
+
+// ClientWithBaseURL sets the base URL of the control plane.
+func ClientWithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) { c.baseURL = baseURL }
+}
+
+// ClientWithConfig sets the non-zero settings of the configuration, such
+// as one returned by LoadConfig for a given profile.
+func ClientWithConfig(cfg *Config) ClientOption {
+	return func(c *Client) {
+		if cfg.APIKey != "" {
+			c.apiKey = cfg.APIKey
+		}
+		if cfg.Domain != "" {
+			c.domain = cfg.Domain
+		}
+		if cfg.Template != "" {
+			c.template = cfg.Template
+		}
+		if cfg.Timeout != 0 {
+			c.timeout = cfg.Timeout
+		}
+		if cfg.RequestTimeout != 0 {
+			c.client = &http.Client{Timeout: cfg.RequestTimeout}
+		}
+	}
+}
+
+// ClientWithDomain sets the domain of a self-hosted E2B deployment.
+//
+// It drives the control plane url, unless set with ClientWithBaseURL, as
+// well as the envd websocket and sandbox host urls.
+func ClientWithDomain(domain string) ClientOption {
+	return func(c *Client) { c.domain = domain }
+}
+
+// ClientWithEnvdPort sets the port envd listens on in sandboxes.
+func ClientWithEnvdPort(port int) ClientOption {
+	return func(c *Client) { c.envdPort = port }
+}
+
+// ClientWithInsecure serves every request over plain http and ws.
+//
+// This is only meant for self-hosted deployments on a trusted network.
+func ClientWithInsecure(insecure bool) ClientOption {
+	return func(c *Client) { c.insecure = insecure }
+}
+
+// ClientWithRetryPolicy sets the policy for retrying idempotent requests,
+// such as DefaultRetryPolicy. By default requests are not retried.
+func ClientWithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) { c.retry = policy }
+}
+
+// ClientWithSandboxLimits caps the sandboxes created by the client to
+// maxConcurrent running at once and createsPerSecond creations per
+// second; zero leaves a limit unset.
+//
+// Callers of Create or Resume over the limits are queued in fifo order. A
+// sandbox frees its slot once stopped, paused or expired.
+func ClientWithSandboxLimits(maxConcurrent int, createsPerSecond float64) ClientOption {
+	return func(c *Client) {
+		c.limits = &sandboxLimits{}
+		if maxConcurrent > 0 {
+			c.limits.running = newSemaphore(maxConcurrent)
+		}
+		if createsPerSecond > 0 {
+			c.limits.creates = newRateLimiter(createsPerSecond)
+		}
+	}
+}
+
+// ClientWithHTTPClient sets the http client of the client.
+func ClientWithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) { c.client = client }
+}
+
+// ClientWithLogger sets the logger of the client and of its sandboxes.
+func ClientWithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) { c.logger = logger }
+}
+
 // E2B Sandbox Options
 
 // WithBaseURL sets the base URL for the e2b sandbox.
 func WithBaseURL(baseURL string) Option {
-	return func(s *Sandbox) { s.baseURL = baseURL }
+	return func(s *Sandbox) {
+		s.api = s.api.with(func(c *Client) { c.baseURL = baseURL })
+	}
+}
+
+// WithDomain sets the domain of a self-hosted E2B deployment.
+//
+// It drives the control plane url, unless set with WithBaseURL, as well
+// as the envd websocket and sandbox host urls.
+func WithDomain(domain string) Option {
+	return func(s *Sandbox) {
+		s.api = s.api.with(func(c *Client) { c.domain = domain })
+	}
+}
+
+// WithEnvdPort sets the port envd listens on in the sandbox.
+func WithEnvdPort(port int) Option {
+	return func(s *Sandbox) {
+		s.api = s.api.with(func(c *Client) { c.envdPort = port })
+	}
+}
+
+// WithInsecure serves every request of the sandbox over plain http and ws.
+//
+// This is only meant for self-hosted deployments on a trusted network.
+func WithInsecure(insecure bool) Option {
+	return func(s *Sandbox) {
+		s.api = s.api.with(func(c *Client) { c.insecure = insecure })
+	}
+}
+
+// WithRetryPolicy sets the policy for retrying the sandbox's idempotent
+// control plane requests, such as DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *Sandbox) {
+		s.api = s.api.with(func(c *Client) { c.retry = policy })
+	}
 }
 
 // WithClient sets the client for the e2b sandbox.
 func WithClient(client *http.Client) Option {
-	return func(s *Sandbox) { s.client = client }
+	return func(s *Sandbox) {
+		s.api = s.api.with(func(c *Client) { c.client = client })
+	}
 }
 
 // WithLogger sets the logger for the e2b sandbox.
 func WithLogger(logger *slog.Logger) Option {
-	return func(s *Sandbox) { s.logger = logger }
+	return func(s *Sandbox) {
+		s.logger = logger
+		s.api = s.api.with(func(c *Client) { c.logger = logger })
+	}
 }
 
 // WithTemplate sets the template for the e2b sandbox.
@@ -32,11 +158,133 @@ func WithMetaData(metaData map[string]string) Option {
 	return func(s *Sandbox) { s.Metadata = metaData }
 }
 
+// WithTimeout sets the duration after which the sandbox is killed unless
+// it is kept alive.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Sandbox) { s.Timeout = int(timeout.Seconds()) }
+}
+
+// WithAutoKeepAlive keeps the sandbox alive in the background by extending
+// its timeout by extension every interval, until the sandbox is stopped.
+func WithAutoKeepAlive(interval, extension time.Duration) Option {
+	return func(s *Sandbox) {
+		s.keepAlive.interval = interval
+		s.keepAlive.extension = extension
+	}
+}
+
+// WithOnKeepAliveFailure sets the function called when the automatic
+// keep-alive can no longer refresh the sandbox before it expires.
+func WithOnKeepAliveFailure(fn KeepAliveFailureFunc) Option {
+	return func(s *Sandbox) { s.keepAlive.onFailure = fn }
+}
+
+// WithEnvVars sets the environment variables of the sandbox.
+//
+// They apply to every process and code interpreter kernel of the sandbox;
+// variables given to a process with ProcessWithEnv take precedence.
+func WithEnvVars(envVars map[string]string) Option {
+	return func(s *Sandbox) { s.EnvVars = envVars }
+}
+
+// WithCPUCount sets the number of vCPUs of the sandbox.
+func WithCPUCount(count int) Option {
+	return func(s *Sandbox) { s.CPUCount = count }
+}
+
+// WithMemoryMB sets the memory of the sandbox in MiB.
+func WithMemoryMB(memory int) Option {
+	return func(s *Sandbox) { s.MemoryMB = memory }
+}
+
+// WithDiskSizeMB sets the disk size of the sandbox in MiB.
+func WithDiskSizeMB(size int) Option {
+	return func(s *Sandbox) { s.DiskSizeMB = size }
+}
+
+// WithInternetAccess sets whether the sandbox can reach the internet.
+func WithInternetAccess(allow bool) Option {
+	return func(s *Sandbox) {
+		if s.Network == nil {
+			s.Network = &NetworkPolicy{}
+		}
+		s.Network.AllowInternetAccess = &allow
+	}
+}
+
+// WithEgressAllowlist restricts the sandbox's egress to the given domains,
+// IPs and CIDRs; all other egress is denied.
+//
+// Domains can have a leading "*." wildcard, e.g. "*.pypi.org".
+func WithEgressAllowlist(rules ...string) Option {
+	return func(s *Sandbox) {
+		if s.Network == nil {
+			s.Network = &NetworkPolicy{}
+		}
+		s.Network.AllowOut = append(s.Network.AllowOut, rules...)
+	}
+}
+
+// WithSecure sets whether the sandbox's envd requires an access token.
+//
+// The token is returned by the control plane when the sandbox is created
+// and sent on every request to envd.
+func WithSecure(secure bool) Option {
+	return func(s *Sandbox) { s.Secure = secure }
+}
+
+// WithEnvdAccessToken sets the envd access token of a secure sandbox.
+//
+// This is needed to connect to a secure sandbox created elsewhere.
+func WithEnvdAccessToken(token string) Option {
+	return func(s *Sandbox) { s.EnvdAccessToken = token }
+}
+
 // WithCwd sets the current working directory.
 func WithCwd(cwd string) Option {
 	return func(s *Sandbox) { s.Cwd = cwd }
 }
 
+// WithWriteTimeout sets the timeout of every write to the sandbox's
+// websocket; a write timing out closes the connection. It defaults to 10
+// seconds.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *Sandbox) { s.wsOpts.writeTimeout = timeout }
+}
+
+// WithReconnectPolicy sets the policy for redialing the sandbox's
+// websocket when it is lost; a zero MaxAttempts disables reconnection.
+//
+// Calls in flight or made while reconnecting fail with ErrConnectionLost,
+// while process and file system subscriptions are resumed once
+// reconnected. By default the websocket is redialed up to 10 times.
+func WithReconnectPolicy(policy RetryPolicy) Option {
+	return func(s *Sandbox) { s.wsOpts.reconnect = policy }
+}
+
+// WithHeartbeat pings the sandbox's websocket every interval and considers
+// it lost once a ping goes unanswered for timeout; a zero interval disables
+// pings. It defaults to a ping every 10 seconds and a 10 seconds timeout.
+//
+// This detects half-open connections, which are then reconnected or
+// reported by Done and Err.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(s *Sandbox) {
+		s.wsOpts.pingInterval = interval
+		s.wsOpts.pongTimeout = timeout
+	}
+}
+
+// WithTransport sets the dialer of the sandbox's envd transport, replacing
+// the websocket dialed by DialWebsocket.
+//
+// The dialer is called once the sandbox is created or connected to, and
+// again by Reconnect. The websocket options, such as WithHeartbeat, only
+// apply to transports dialed with DialWebsocket.
+func WithTransport(dial TransportDialer) Option {
+	return func(s *Sandbox) { s.transport = dial }
+}
+
 // WithWsURL sets the websocket url resolving function for the e2b sandbox.
 //
 // This is useful for testing.
@@ -44,6 +292,43 @@ func WithWsURL(wsURL func(s *Sandbox) string) Option {
 	return func(s *Sandbox) { s.wsURL = wsURL }
 }
 
+// Pool Options
+
+// PoolWithSandboxOptions sets the options of the pool's sandboxes, such as
+// WithTemplate.
+func PoolWithSandboxOptions(opts ...Option) PoolOption {
+	return func(p *Pool) { p.opts = append(p.opts, opts...) }
+}
+
+// PoolWithMaxAge kills the pool's sandboxes once older than maxAge instead
+// of handing them out or recycling them.
+func PoolWithMaxAge(maxAge time.Duration) PoolOption {
+	return func(p *Pool) { p.maxAge = maxAge }
+}
+
+// PoolWithHealthCheck checks the idle sandboxes every interval and when
+// they are acquired; the sandboxes failing the check are killed. A zero
+// interval only checks them when acquired.
+func PoolWithHealthCheck(interval time.Duration, check func(ctx context.Context, s *Sandbox) error) PoolOption {
+	return func(p *Pool) {
+		p.healthInterval = interval
+		p.healthCheck = check
+	}
+}
+
+// PoolWithReset recycles the released sandboxes that reset succeeds on,
+// instead of killing them.
+func PoolWithReset(reset func(ctx context.Context, s *Sandbox) error) PoolOption {
+	return func(p *Pool) { p.reset = reset }
+}
+
+// PoolWithKeepAlive keeps the pool's sandboxes alive in the background, as
+// with WithAutoKeepAlive, until they are stopped; otherwise the pool only
+// keeps its idle sandboxes alive.
+func PoolWithKeepAlive(interval, extension time.Duration) PoolOption {
+	return func(p *Pool) { p.opts = append(p.opts, WithAutoKeepAlive(interval, extension)) }
+}
+
 // Process Options
 
 // ProcessWithEnv sets the environment variables for the process.
@@ -55,3 +340,37 @@ func ProcessWithEnv(env map[string]string) ProcessOption {
 func ProcessWithCwd(cwd string) ProcessOption {
 	return func(p *Process) { p.Cwd = cwd }
 }
+
+// List Options
+
+// ListWithMetadata filters listed sandboxes by a metadata key/value pair.
+//
+// It can be given multiple times; sandboxes must match every pair.
+func ListWithMetadata(key, value string) ListOption {
+	return func(q *listQuery) {
+		if q.metadata == nil {
+			q.metadata = map[string]string{}
+		}
+		q.metadata[key] = value
+	}
+}
+
+// ListWithTemplate filters listed sandboxes by template id or alias.
+func ListWithTemplate(template SandboxTemplate) ListOption {
+	return func(q *listQuery) { q.template = template }
+}
+
+// ListWithState filters listed sandboxes by state.
+func ListWithState(states ...SandboxState) ListOption {
+	return func(q *listQuery) { q.states = append(q.states, states...) }
+}
+
+// ListWithLimit sets the maximum number of sandboxes in a page.
+func ListWithLimit(limit int) ListOption {
+	return func(q *listQuery) { q.limit = limit }
+}
+
+// ListWithNextToken sets the token of the page to fetch.
+func ListWithNextToken(token string) ListOption {
+	return func(q *listQuery) { q.nextToken = token }
+}
@@ -0,0 +1,316 @@
+package e2b
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Pool keeps sandboxes created and connected ahead of time, so that
+	// acquiring one does not pay the sandbox's startup time.
+	//
+	// A pool is safe for concurrent use.
+	Pool struct {
+		client         *Client
+		size           int                                   // size is the number of idle sandboxes kept warm.
+		opts           []Option                              // opts are the options of the pool's sandboxes.
+		maxAge         time.Duration                         // maxAge is the age after which sandboxes are killed; zero if unlimited.
+		healthInterval time.Duration                         // healthInterval is the interval between health checks of idle sandboxes; zero if only on Acquire.
+		healthCheck    func(context.Context, *Sandbox) error // healthCheck checks idle sandboxes; nil if none.
+		reset          func(context.Context, *Sandbox) error // reset prepares released sandboxes for reuse; nil kills them.
+
+		mu       sync.Mutex
+		idle     []*Sandbox // idle are the warm sandboxes, oldest first.
+		pending  int        // pending is the number of sandboxes being created.
+		checking int        // checking is the number of idle sandboxes being checked.
+		closed   bool
+
+		wake   chan struct{}      // wake wakes the maintenance goroutine.
+		cancel context.CancelFunc // cancel stops the maintenance goroutine.
+		done   chan struct{}      // done is closed once the maintenance goroutine returned.
+	}
+
+	// PoolOption is an option for the pool.
+	PoolOption func(*Pool)
+)
+
+const (
+	defaultPoolCheckInterval = 30 * time.Second
+	// poolRefreshMargin is how close to expiring idle sandboxes are kept
+	// alive; it spans two checks.
+	poolRefreshMargin = 2 * defaultPoolCheckInterval
+	// poolExpiryMargin is how close to expiring idle sandboxes are evicted.
+	poolExpiryMargin = defaultPoolCheckInterval
+)
+
+var (
+	// ErrPoolClosed is returned when acquiring from a closed pool.
+	ErrPoolClosed = errors.New("e2b: pool closed")
+
+	errPoolExpired = errors.New("e2b: pooled sandbox expired")
+)
+
+// NewPool creates a pool keeping size sandboxes of the client warm.
+//
+// The pool fills up in the background; Acquire creates a sandbox on demand
+// when none is warm yet. Idle sandboxes are kept alive by the pool unless
+// their options keep them alive, and are evicted once about to expire.
+func NewPool(client *Client, size int, opts ...PoolOption) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		client: client,
+		size:   size,
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	go p.run(ctx)
+	return p
+}
+
+// Acquire hands out a warm sandbox, or creates one if none is warm.
+//
+// Warm sandboxes older than the pool's max age, about to expire or failing
+// its health check are killed and skipped.
+func (p *Pool) Acquire(ctx context.Context) (*Sandbox, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		sb := p.idle[0]
+		p.idle = p.idle[1:]
+		p.mu.Unlock()
+		p.signal()
+
+		if err := p.ready(ctx, sb, true); err != nil {
+			p.client.logger.Warn("evicting pooled sandbox", "sandbox", sb.ID, "error", err)
+			go p.kill(sb)
+			continue
+		}
+		return sb, nil
+	}
+	p.signal()
+	return p.create(ctx)
+}
+
+// Release hands a sandbox back to the pool.
+//
+// The sandbox is reset and kept warm for reuse if the pool has a reset
+// function, is not full and the sandbox is not too old; it is killed
+// otherwise.
+func (p *Pool) Release(ctx context.Context, sb *Sandbox) error {
+	if p.reset == nil || p.expired(sb) {
+		return p.kill(sb)
+	}
+	if err := p.reset(ctx, sb); err != nil {
+		p.client.logger.Warn("failed to reset pooled sandbox", "sandbox", sb.ID, "error", err)
+		return p.kill(sb)
+	}
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		return p.kill(sb)
+	}
+	p.idle = append(p.idle, sb)
+	p.mu.Unlock()
+	return nil
+}
+
+// Close stops maintaining the pool and kills its idle sandboxes.
+//
+// Sandboxes acquired from the pool are left running.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	p.cancel()
+	<-p.done
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	var errs []error
+	for _, sb := range idle {
+		if err := sb.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run maintains the pool until its context is canceled: it keeps the idle
+// sandboxes alive, evicts the expired and unhealthy ones and refills the
+// pool.
+func (p *Pool) run(ctx context.Context) {
+	defer close(p.done)
+	interval := defaultPoolCheckInterval
+	if p.healthInterval > 0 {
+		interval = min(interval, p.healthInterval)
+	}
+	nextHealth := time.Now().Add(p.healthInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	check := false
+	for {
+		if check {
+			health := p.healthInterval > 0 && !time.Now().Before(nextHealth)
+			if health {
+				nextHealth = time.Now().Add(p.healthInterval)
+			}
+			p.check(ctx, health)
+		}
+		p.fill(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+			check = false
+		case <-ticker.C:
+			check = true
+		}
+	}
+}
+
+// check readies the idle sandboxes, running the health check if asked, and
+// kills those that fail.
+//
+// Sandboxes are checked one at a time, newest first, so that the others
+// can still be acquired meanwhile.
+func (p *Pool) check(ctx context.Context, health bool) {
+	p.mu.Lock()
+	idle := slices.Clone(p.idle)
+	p.mu.Unlock()
+
+	for _, sb := range slices.Backward(idle) {
+		p.mu.Lock()
+		i := slices.Index(p.idle, sb)
+		if i < 0 {
+			// Acquired meanwhile.
+			p.mu.Unlock()
+			continue
+		}
+		p.idle = slices.Delete(p.idle, i, i+1)
+		p.checking++
+		p.mu.Unlock()
+
+		err := p.ready(ctx, sb, health)
+		p.mu.Lock()
+		p.checking--
+		if err == nil {
+			p.idle = slices.Insert(p.idle, 0, sb)
+		}
+		p.mu.Unlock()
+		if err != nil {
+			p.client.logger.Warn("evicting pooled sandbox", "sandbox", sb.ID, "error", err)
+			go p.kill(sb)
+			p.signal()
+		}
+	}
+}
+
+// ready readies an idle sandbox to stay warm or be handed out: it keeps
+// the sandbox alive if about to expire, unless its options keep it alive,
+// then runs the health check if asked. A sandbox failing is to be killed.
+func (p *Pool) ready(ctx context.Context, sb *Sandbox, health bool) error {
+	if !p.expired(sb) && sb.keepAlive.interval <= 0 && time.Until(sb.ExpiresAt()) < poolRefreshMargin {
+		timeout := defaultTimeout
+		if sb.Timeout > 0 {
+			timeout = time.Duration(sb.Timeout) * time.Second
+		}
+		if err := sb.KeepAlive(ctx, timeout); err != nil {
+			p.client.logger.Warn("failed to keep pooled sandbox alive", "sandbox", sb.ID, "error", err)
+		}
+	}
+	if p.expired(sb) {
+		return errPoolExpired
+	}
+	if health && p.healthCheck != nil {
+		if err := p.healthCheck(ctx, sb); err != nil {
+			return fmt.Errorf("health check failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// fill starts creating the sandboxes missing to fill the pool.
+func (p *Pool) fill(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ; !p.closed && len(p.idle)+p.pending+p.checking < p.size; p.pending++ {
+		go func() {
+			sb, err := p.create(ctx)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.pending--
+			if err != nil {
+				// Retried on the next check rather than right away.
+				p.client.logger.Error("failed to create pooled sandbox", "error", err)
+				return
+			}
+			if p.closed || len(p.idle) >= p.size {
+				// Closed, or filled by released sandboxes meanwhile.
+				go p.kill(sb)
+				return
+			}
+			p.idle = append(p.idle, sb)
+		}()
+	}
+}
+
+// create creates a sandbox of the pool.
+func (p *Pool) create(ctx context.Context) (*Sandbox, error) {
+	sb, err := p.client.Create(ctx, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if sb.StartedAt.IsZero() {
+		// The sandbox's age counts from its start.
+		sb.StartedAt = time.Now()
+	}
+	return sb, nil
+}
+
+// kill kills a sandbox of the pool.
+func (p *Pool) kill(sb *Sandbox) error {
+	err := sb.Stop(context.Background())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		p.client.logger.Warn("failed to kill pooled sandbox", "sandbox", sb.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// expired reports whether a sandbox is older than the pool's max age or
+// about to expire.
+func (p *Pool) expired(sb *Sandbox) bool {
+	if time.Until(sb.ExpiresAt()) < poolExpiryMargin {
+		return true
+	}
+	return p.maxAge > 0 && !sb.StartedAt.IsZero() && time.Since(sb.StartedAt) > p.maxAge
+}
+
+// signal wakes the maintenance goroutine to refill the pool.
+func (p *Pool) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
@@ -2,8 +2,9 @@ package e2b
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"math/rand"
 )
 
@@ -63,39 +64,35 @@ func (s *Sandbox) NewProcess(
 }
 
 // Start starts a process in the sandbox.
+//
+// The process's environment is the sandbox's environment variables
+// overridden by the process's own. Without process environment variables,
+// PYTHONUNBUFFERED=1 is set unless the sandbox sets it.
 func (p *Process) Start(ctx context.Context) (err error) {
+	env := map[string]string{}
 	if p.Env == nil {
-		p.Env = map[string]string{"PYTHONUNBUFFERED": "1"}
+		env["PYTHONUNBUFFERED"] = "1"
 	}
-	respCh := make(chan []byte)
-	err = p.sb.writeRequest(
-		ctx,
-		processStart,
-		[]any{p.id, p.cmd, p.Env, p.Cwd},
-		respCh,
-	)
+	maps.Copy(env, p.sb.EnvVars)
+	maps.Copy(env, p.Env)
+	msg, err := p.sb.envd().Call(ctx, processStart, []any{p.id, p.cmd, env, p.Cwd})
 	if err != nil {
 		return err
 	}
-	select {
-	case body := <-respCh:
-		res, err := decodeResponse[string, APIError](body)
-		if err != nil {
-			return err
-		}
-		if res.Error.Code != 0 {
-			return fmt.Errorf("process start failed(%d): %s", res.Error.Code, res.Error.Message)
-		}
-		if res.Result == "" || len(res.Result) == 0 {
-			return fmt.Errorf("process start failed got empty result id")
-		}
-		if p.id != res.Result {
-			return fmt.Errorf("process start failed got wrong result id; want %s, got %s", p.id, res.Result)
-		}
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+	res, err := decodeResponse[string, APIError](msg)
+	if err != nil {
+		return err
+	}
+	if res.Error.Code != 0 {
+		return fmt.Errorf("process start failed(%d): %s", res.Error.Code, res.Error.Message)
+	}
+	if res.Result == "" || len(res.Result) == 0 {
+		return fmt.Errorf("process start failed got empty result id")
 	}
+	if p.id != res.Result {
+		return fmt.Errorf("process start failed got wrong result id; want %s, got %s", p.id, res.Result)
+	}
+	return nil
 }
 
 // Done returns a channel that is closed when the process is done.
@@ -132,43 +129,53 @@ func (p *Process) subscribe(
 	events := make(chan Event)
 	errs := make(chan error)
 	go func(errCh chan error) {
-		respCh := make(chan []byte)
-		defer close(respCh)
-		err := p.sb.writeRequest(ctx, processSubscribe, []any{event, p.id}, respCh)
-		if err != nil {
-			errCh <- err
-		}
-		res, err := decodeResponse[string, any](<-respCh)
+		sub, err := p.sb.envd().Subscribe(ctx, processSubscribe, []any{event, p.id})
 		if err != nil {
-			errCh <- err
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+			return
 		}
-		p.sb.Map.Store(res.Result, respCh)
 	loop:
 		for {
-			select {
-			case eventBd := <-respCh:
-				var event Event
-				_ = json.Unmarshal(eventBd, &event)
-				if event.Error != "" {
-					p.sb.logger.Error("failed to read event", "error", event.Error)
-					continue
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					// The connection is done for good.
+					select {
+					case errCh <- err:
+					case <-ctx.Done():
+					}
 				}
-				events <- event
-			case <-ctx.Done():
 				break loop
-			case <-p.Done():
+			}
+			event, err := newEvent(msg)
+			if err != nil || event.Error != "" {
+				p.sb.logger.Error("failed to read event", "error", err, "event_error", event.Error)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
 				break loop
 			}
 		}
 
-		p.sb.Map.Delete(res.Result)
-		finishCtx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		p.sb.logger.Debug("unsubscribing from process", "event", event, "id", res.Result)
-		_ = p.sb.writeRequest(finishCtx, processUnsubscribe, []any{res.Result}, respCh)
-		unsubRes, _ := decodeResponse[bool, string](<-respCh)
-		if unsubRes.Error != "" || !unsubRes.Result {
-			p.sb.logger.Debug("failed to unsubscribe from process", "error", unsubRes.Error)
+		sub.Close()
+		id := sub.ID()
+		p.sb.logger.Debug("unsubscribing from process", "event", event, "id", id)
+		msg, err := p.sb.envd().Call(context.Background(), processUnsubscribe, []any{id})
+		if err != nil {
+			p.sb.logger.Debug("failed to unsubscribe from process", "error", err)
+			return
+		}
+		unsubRes, err := decodeResponse[bool, string](msg)
+		if err == nil && (unsubRes.Error != "" || !unsubRes.Result) {
+			err = errors.New(unsubRes.Error)
+		}
+		if err != nil {
+			p.sb.logger.Debug("failed to unsubscribe from process", "error", err)
 		}
 	}(errs)
 	return events, errs
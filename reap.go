@@ -0,0 +1,105 @@
+package e2b
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// ReapReport is the outcome of a Reap.
+	ReapReport struct {
+		Killed []SandboxInfo // Killed are the sandboxes that were killed.
+		Failed []ReapFailure // Failed are the sandboxes that could not be killed.
+	}
+
+	// ReapFailure is a sandbox that could not be killed by Reap.
+	ReapFailure struct {
+		Sandbox SandboxInfo // Sandbox that could not be killed.
+		Err     error       // Err is the error of the kill request.
+	}
+)
+
+const reapConcurrency = 8
+
+// Reap kills the sandboxes whose metadata matches every key/value pair of
+// the selector and that were started more than olderThan ago.
+//
+// The selector must not be empty, so that a mistake never reaps every
+// sandbox of the team. Matching sandboxes are killed concurrently. An error
+// is only returned when the selector is empty or the sandboxes cannot be
+// listed; failed kills are reported in the report, while sandboxes already
+// gone are left out of it.
+func (c *Client) Reap(
+	ctx context.Context,
+	selector map[string]string,
+	olderThan time.Duration,
+) (*ReapReport, error) {
+	if len(selector) == 0 {
+		return nil, errors.New("reap failed: empty selector")
+	}
+	opts := []ListOption{ListWithState(SandboxStateRunning, SandboxStatePaused)}
+	for k, v := range selector {
+		opts = append(opts, ListWithMetadata(k, v))
+	}
+	var matches []SandboxInfo
+	cutoff := time.Now().Add(-olderThan)
+	token := ""
+	for {
+		page, err := c.List(ctx, append(opts, ListWithNextToken(token))...)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range page.Sandboxes {
+			if info.StartedAt.After(cutoff) || !matchesSelector(info.Metadata, selector) {
+				continue
+			}
+			matches = append(matches, info)
+		}
+		token = page.NextToken
+		if token == "" {
+			break
+		}
+	}
+
+	report := ReapReport{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, reapConcurrency)
+	for _, info := range matches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := c.Kill(ctx, info.ID)
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, ErrNotFound) {
+				c.logger.Debug("reaped sandbox already gone", "sandbox", info.ID)
+				return
+			}
+			if err != nil {
+				c.logger.Warn("failed to reap sandbox", "sandbox", info.ID, "error", err)
+				report.Failed = append(report.Failed, ReapFailure{Sandbox: info, Err: err})
+				return
+			}
+			c.logger.Debug("reaped sandbox", "sandbox", info.ID, "startedAt", info.StartedAt)
+			report.Killed = append(report.Killed, info)
+		}()
+	}
+	wg.Wait()
+	return &report, nil
+}
+
+// matchesSelector reports whether the metadata has every key/value pair of
+// the selector.
+func matchesSelector(metadata, selector map[string]string) bool {
+	for k, v := range selector {
+		if metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
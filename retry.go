@@ -0,0 +1,113 @@
+package e2b
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	// RetryPolicy is the policy for retrying idempotent control plane
+	// requests that failed on a network error, a 5xx or a 429 response.
+	//
+	// The backoff between attempts grows exponentially from InitialBackoff
+	// up to MaxBackoff, with jitter. A Retry-After response header takes
+	// precedence over the backoff. No attempt is made when it would not
+	// complete before the request's context deadline.
+	RetryPolicy struct {
+		MaxAttempts    int           // MaxAttempts is the maximum number of attempts, including the first one.
+		InitialBackoff time.Duration // InitialBackoff is the backoff after the first attempt.
+		MaxBackoff     time.Duration // MaxBackoff caps the backoff between attempts.
+	}
+
+	// idempotentKey marks the context of a request safe to retry.
+	idempotentKey struct{}
+)
+
+// DefaultRetryPolicy is a retry policy suitable for most clients.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// idempotent marks the request safe to retry even though its method is not
+// idempotent.
+func idempotent(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), idempotentKey{}, true))
+}
+
+// isIdempotent reports whether the request is safe to retry.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// retryable reports whether a failed attempt can succeed when retried.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var cpErr *ControlPlaneError
+	if errors.As(err, &cpErr) {
+		return cpErr.StatusCode >= http.StatusInternalServerError ||
+			cpErr.StatusCode == http.StatusTooManyRequests
+	}
+	// Any other error is a network error.
+	return true
+}
+
+// backoff returns the wait after the given failed attempt.
+func (p *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var cpErr *ControlPlaneError
+	if errors.As(err, &cpErr) && cpErr.RetryAfter > 0 {
+		return cpErr.RetryAfter
+	}
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 {
+		backoff = min(backoff, p.MaxBackoff)
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	// Equal jitter: half fixed, half random.
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// parseRetryAfter parses a Retry-After header, either in seconds or as an
+// http date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// rewind returns a copy of the request with a fresh body to send it again.
+func rewind(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
@@ -2,8 +2,8 @@ package e2b
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"sync"
@@ -20,19 +20,33 @@ type (
 	//
 	// The sandbox is like an isolated, but interactive system.
 	Sandbox struct {
-		ID       string                  `json:"sandboxID"`  // ID of the sandbox.
-		ClientID string                  `json:"clientID"`   // ClientID of the sandbox.
-		Cwd      string                  `json:"cwd"`        // Cwd is the sandbox's current working directory.
-		apiKey   string                  `json:"-"`          // apiKey is the sandbox's api key.
-		Template SandboxTemplate         `json:"templateID"` // Template of the sandbox.
-		baseURL  string                  `json:"-"`          // baseAPIURL is the base api url of the sandbox.
-		Metadata map[string]string       `json:"metadata"`   // Metadata of the sandbox.
-		logger   *slog.Logger            `json:"-"`          // logger is the sandbox's logger.
-		client   *http.Client            `json:"-"`          // client is the sandbox's http client.
-		ws       *websocket.Conn         `json:"-"`          // ws is the sandbox's websocket connection.
-		wsURL    func(s *Sandbox) string `json:"-"`          // wsURL is the sandbox's websocket url.
-		Map      *sync.Map               `json:"-"`          // Map is the map of the sandbox.
-		idCh     chan int                `json:"-"`          // idCh is the channel to generate ids for requests.
+		ID              string                  `json:"sandboxID"`                 // ID of the sandbox.
+		ClientID        string                  `json:"clientID"`                  // ClientID of the sandbox.
+		Cwd             string                  `json:"cwd"`                       // Cwd is the sandbox's current working directory.
+		Template        SandboxTemplate         `json:"templateID"`                // Template of the sandbox.
+		Metadata        map[string]string       `json:"metadata"`                  // Metadata of the sandbox.
+		Timeout         int                     `json:"timeout,omitempty"`         // Timeout of the sandbox in seconds.
+		EnvVars         map[string]string       `json:"envVars,omitempty"`         // EnvVars are the environment variables of every process of the sandbox.
+		CPUCount        int                     `json:"cpuCount,omitempty"`        // CPUCount is the number of vCPUs of the sandbox.
+		MemoryMB        int                     `json:"memoryMB,omitempty"`        // MemoryMB is the memory of the sandbox in MiB.
+		DiskSizeMB      int                     `json:"diskSizeMB,omitempty"`      // DiskSizeMB is the disk size of the sandbox in MiB.
+		Network         *NetworkPolicy          `json:"network,omitempty"`         // Network is the sandbox's effective egress policy.
+		Secure          bool                    `json:"secure,omitempty"`          // Secure is true if envd requires an access token.
+		EnvdAccessToken string                  `json:"envdAccessToken,omitempty"` // EnvdAccessToken authenticates requests to envd.
+		StartedAt       time.Time               `json:"startedAt,omitzero"`        // StartedAt is when the sandbox was started.
+		EndAt           time.Time               `json:"endAt,omitzero"`            // EndAt is when the sandbox will be killed, if reported.
+		logger          *slog.Logger            `json:"-"`                         // logger is the sandbox's logger.
+		api             *Client                 `json:"-"`                         // api is the sandbox's control plane client.
+		conn            Transport               `json:"-"`                         // conn is the sandbox's envd transport.
+		transport       TransportDialer         `json:"-"`                         // transport dials the sandbox's envd transport.
+		wsOpts          wsOptions               `json:"-"`                         // wsOpts are the options of the websocket transport.
+		wsURL           func(s *Sandbox) string `json:"-"`                         // wsURL is the sandbox's websocket url.
+		Map             *sync.Map               `json:"-"`                         // Map is the map of the sandbox.
+		mu              sync.Mutex              `json:"-"`                         // mu guards conn, expiresAt, expiry and keepAlive.cancel.
+		expiresAt       time.Time               `json:"-"`                         // expiresAt is when the sandbox is expected to be killed.
+		expiry          *time.Timer             `json:"-"`                         // expiry releases the sandbox's slot once it expires, if limited.
+		keepAlive       keepAlive               `json:"-"`                         // keepAlive is the automatic keep-alive configuration.
+		release         func()                  `json:"-"`                         // release releases the sandbox's slot in the client's limits.
 	}
 
 	// Option is an option for the sandbox.
@@ -40,65 +54,38 @@ type (
 )
 
 const (
-	defaultBaseURL            = "https://api.e2b.dev"
-	defaultWSScheme           = "wss"
-	wsRoute                   = "/ws"
-	fileRoute                 = "/file"
-	sandboxesRoute            = "/sandboxes"  // (GET/POST /sandboxes)
-	deleteSandboxRoute        = "/sandboxes/" // (DELETE /sandboxes/:id)
-	notebookExecCell   Method = "notebook_execCell"
+	defaultDomain                         = "e2b.dev"
+	defaultTemplate       SandboxTemplate = "base"
+	defaultEnvdPort                       = 49982
+	domainEnv                             = "E2B_DOMAIN"
+	defaultTimeout                        = 5 * time.Minute
+	defaultWSScheme                       = "wss"
+	envdAccessTokenHeader                 = "X-Access-Token"
+	wsRoute                               = "/ws"
+	fileRoute                             = "/file"
+	sandboxesRoute                        = "/sandboxes"  // (GET/POST /sandboxes)
+	deleteSandboxRoute                    = "/sandboxes/" // (DELETE /sandboxes/:id)
+	notebookExecCell      Method          = "notebook_execCell"
 )
 
+// closedCh is a closed channel.
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
 // NewSandbox creates a new sandbox.
+//
+// It is a shorthand for creating the sandbox with a new Client; prefer a
+// shared Client when managing many sandboxes. An empty api key is resolved
+// from the environment or the config file, see Config.
 func NewSandbox(
 	ctx context.Context,
 	apiKey string,
 	opts ...Option,
 ) (*Sandbox, error) {
-	sb := Sandbox{
-		apiKey:   apiKey,
-		Template: "base",
-		baseURL:  defaultBaseURL,
-		Metadata: map[string]string{
-			"sdk": "e2b-go v1",
-		},
-		client: http.DefaultClient,
-		logger: slog.New(slog.NewJSONHandler(io.Discard, nil)),
-		idCh:   make(chan int),
-		Map:    new(sync.Map),
-		wsURL: func(s *Sandbox) string {
-			return fmt.Sprintf("wss://49982-%s-%s.e2b.dev/ws", s.ID, s.ClientID)
-		},
-	}
-	for _, opt := range opts {
-		opt(&sb)
-	}
-	req, err := sb.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s", sb.baseURL, sandboxesRoute), &sb)
-	if err != nil {
-		return &sb, err
-	}
-	err = sb.sendRequest(req, &sb)
-	if err != nil {
-		return &sb, err
-	}
-	var resp *http.Response
-	sb.ws, resp, err = websocket.DefaultDialer.Dial(sb.wsURL(&sb), nil)
-	if resp != nil {
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-	}
-	if err != nil {
-		return &sb, err
-	}
-	go sb.identify(ctx)
-	go func() {
-		err := sb.read(ctx)
-		if err != nil {
-			sb.logger.Error("failed to read sandbox", "error", err)
-		}
-	}()
-	return &sb, nil
+	return NewClient(apiKey).Create(ctx, opts...)
 }
 
 // ConnectSandbox connects to an existing sandbox.
@@ -108,52 +95,89 @@ func ConnectSandbox(
 	apiKey string,
 	opts ...Option,
 ) (*Sandbox, error) {
-	sb := Sandbox{
-		ID:      sandboxID,
-		apiKey:  apiKey,
-		baseURL: defaultBaseURL,
-		Metadata: map[string]string{
-			"sdk": "e2b-go v1",
-		},
-		client: http.DefaultClient,
-		logger: slog.New(slog.NewJSONHandler(io.Discard, nil)),
-		idCh:   make(chan int),
-		Map:    new(sync.Map),
-		wsURL: func(s *Sandbox) string {
-			return fmt.Sprintf("wss://49982-%s-%s.e2b.dev/ws", s.ID, s.ClientID)
-		},
-	}
-	for _, opt := range opts {
-		opt(&sb)
-	}
-
-	req, err := sb.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s", sb.baseURL, sandboxesRoute, sandboxID), nil)
+	return NewClient(apiKey).Connect(ctx, sandboxID, opts...)
+}
+
+// ResumeSandbox resumes a paused sandbox and connects to it.
+//
+// The sandbox's file system, memory and running processes are restored
+// as they were when it was paused.
+func ResumeSandbox(
+	ctx context.Context,
+	sandboxID string,
+	apiKey string,
+	opts ...Option,
+) (*Sandbox, error) {
+	return NewClient(apiKey).Resume(ctx, sandboxID, opts...)
+}
+
+// start starts the sandbox's client side once the control plane has
+// responded: it dials the transport and starts the automatic keep-alive.
+func (s *Sandbox) start(ctx context.Context) error {
+	s.resetExpiry()
+	s.releaseOnExpiry()
+	err := s.dial(ctx)
 	if err != nil {
-		return &sb, err
+		return err
 	}
-	err = sb.sendRequest(req, &sb)
+	s.startKeepAlive()
+	return nil
+}
+
+// dial dials the sandbox's transport.
+func (s *Sandbox) dial(ctx context.Context) error {
+	conn, err := s.transport(ctx, s)
 	if err != nil {
-		return &sb, err
+		return err
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+	return nil
+}
+
+// envd returns the sandbox's transport, or one failing every call with
+// ErrNotConnected if it never connected.
+func (s *Sandbox) envd() Transport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return notConnected{}
+	}
+	return s.conn
+}
 
-	var resp *http.Response
-	sb.ws, resp, err = websocket.DefaultDialer.Dial(sb.wsURL(&sb), nil)
+// notConnected is the transport of a sandbox that never connected.
+type notConnected struct{}
+
+func (notConnected) Call(context.Context, Method, []any) (*Message, error) {
+	return nil, ErrNotConnected
+}
+
+func (notConnected) Subscribe(context.Context, Method, []any) (Subscription, error) {
+	return nil, ErrNotConnected
+}
+
+func (notConnected) Close() error          { return nil }
+func (notConnected) Done() <-chan struct{} { return closedCh }
+func (notConnected) Err() error            { return ErrNotConnected }
+
+// dialWS dials a websocket to the sandbox's envd.
+func (s *Sandbox) dialWS(ctx context.Context) (*websocket.Conn, error) {
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, s.wsURL(s), s.envdHeader())
 	if resp != nil {
-		defer func() {
-			_ = resp.Body.Close()
-		}()
+		_ = resp.Body.Close()
 	}
-	if err != nil {
-		return &sb, err
+	return ws, err
+}
+
+// envdHeader returns the headers of requests to envd.
+func (s *Sandbox) envdHeader() http.Header {
+	header := http.Header{}
+	if s.EnvdAccessToken != "" {
+		header.Set(envdAccessTokenHeader, s.EnvdAccessToken)
 	}
-	go sb.identify(ctx)
-	go func() {
-		err := sb.read(ctx)
-		if err != nil {
-			sb.logger.Error("failed to read sandbox", "error", err)
-		}
-	}()
-	return &sb, nil
+	return header
 }
 
 // KeepAlive keeps the sandbox alive.
@@ -161,67 +185,123 @@ func (s *Sandbox) KeepAlive(ctx context.Context, timeout time.Duration) error {
 	body := struct {
 		Duration int `json:"duration"`
 	}{Duration: int(timeout.Seconds())}
-	req, err := s.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s/sandboxes/%s/refreshes", s.baseURL, s.ID), body)
+	req, err := s.api.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s/sandboxes/%s/refreshes", s.api.apiURL(), s.ID), body)
 	if err != nil {
 		return err
 	}
-	resp, err := s.client.Do(req)
+	err = s.api.sendRequest(idempotent(req), nil)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	if resp.StatusCode < http.StatusOK ||
-		resp.StatusCode >= http.StatusBadRequest {
-		return fmt.Errorf("request to keep alive sandbox failed: %s", resp.Status)
-	}
+	s.setExpiresAt(time.Now().Add(timeout))
 	return nil
 }
 
-// Reconnect reconnects to the sandbox.
-func (s *Sandbox) Reconnect(ctx context.Context) (err error) {
-	if err := s.ws.Close(); err != nil {
+// SetTimeout sets the sandbox to be killed after the given duration
+// from now.
+//
+// Unlike KeepAlive, the timeout can also be shortened.
+func (s *Sandbox) SetTimeout(ctx context.Context, timeout time.Duration) error {
+	body := struct {
+		Timeout int `json:"timeout"`
+	}{Timeout: int(timeout.Seconds())}
+	req, err := s.api.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s/%s/timeout", s.api.apiURL(), sandboxesRoute, s.ID), body)
+	if err != nil {
 		return err
 	}
-	urlu := fmt.Sprintf("wss://49982-%s-%s.e2b.dev/ws", s.ID, s.ClientID)
-	var resp *http.Response
-	s.ws, resp, err = websocket.DefaultDialer.Dial(urlu, nil)
-	if resp != nil {
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-	}
+	err = s.api.sendRequest(idempotent(req), nil)
 	if err != nil {
 		return err
 	}
-	go func() {
-		err := s.read(ctx)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}()
-	return err
+	s.setExpiresAt(time.Now().Add(timeout))
+	return nil
 }
 
-// Stop stops the sandbox.
-func (s *Sandbox) Stop(ctx context.Context) error {
-	req, err := s.newRequest(ctx, http.MethodDelete, fmt.Sprintf("%s%s%s", s.baseURL, deleteSandboxRoute, s.ID), nil)
+// ExpiresAt returns when the sandbox is expected to be killed.
+//
+// It is taken from the control plane's response when available and
+// otherwise computed from the last timeout set by the sdk.
+func (s *Sandbox) ExpiresAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiresAt
+}
+
+func (s *Sandbox) setExpiresAt(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiresAt = t
+	if s.expiry != nil {
+		s.expiry.Reset(time.Until(t))
+	}
+}
+
+// resetExpiry sets the expiry from the control plane's last response.
+func (s *Sandbox) resetExpiry() {
+	if !s.EndAt.IsZero() {
+		s.setExpiresAt(s.EndAt)
+		return
+	}
+	timeout := defaultTimeout
+	if s.Timeout > 0 {
+		timeout = time.Duration(s.Timeout) * time.Second
+	}
+	s.setExpiresAt(time.Now().Add(timeout))
+}
+
+// Reconnect reconnects to the sandbox.
+//
+// The calls and subscriptions of the previous connection fail.
+func (s *Sandbox) Reconnect(ctx context.Context) error {
+	if err := s.envd().Close(); err != nil {
+		return err
+	}
+	return s.dial(ctx)
+}
+
+// Done returns a channel closed once the sandbox's transport is closed or
+// lost for good, i.e. it could not be reconnected. It is closed already if
+// the sandbox never connected.
+func (s *Sandbox) Done() <-chan struct{} {
+	return s.envd().Done()
+}
+
+// Err returns why the sandbox's transport is done, or nil if it is not.
+//
+// A lost transport matches ErrConnectionLost and a sandbox that never
+// connected ErrNotConnected.
+func (s *Sandbox) Err() error {
+	return s.envd().Err()
+}
+
+// Pause pauses the sandbox.
+//
+// A paused sandbox keeps its state but is not billed; resume it with
+// ResumeSandbox. The sandbox's transport is closed, so the sandbox must not
+// be used after pausing.
+func (s *Sandbox) Pause(ctx context.Context) error {
+	req, err := s.api.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s/%s/pause", s.api.apiURL(), sandboxesRoute, s.ID), nil)
 	if err != nil {
 		return err
 	}
-	resp, err := s.client.Do(req)
+	err = s.api.sendRequest(req, nil)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	if resp.StatusCode < http.StatusOK ||
-		resp.StatusCode >= http.StatusBadRequest {
-		return fmt.Errorf("request to delete sandbox failed: %s", resp.Status)
+	s.stopKeepAlive()
+	s.release()
+	return s.envd().Close()
+}
+
+// Stop stops the sandbox.
+func (s *Sandbox) Stop(ctx context.Context) error {
+	s.stopKeepAlive()
+	err := s.api.Kill(ctx, s.ID)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		s.release()
+		_ = s.envd().Close()
 	}
-	return nil
+	return err
 }
 
 // Close is an alias for Stop.
@@ -231,5 +311,5 @@ func (s *Sandbox) Close(ctx context.Context) error {
 
 // GetHost returns the host address for the specified port.
 func (s *Sandbox) GetHost(port int) string {
-	return fmt.Sprintf("%d-%s-%s.e2b.dev", port, s.ID, s.ClientID)
+	return fmt.Sprintf("%d-%s-%s.%s", port, s.ID, s.ClientID, s.api.domain)
 }
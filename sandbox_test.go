@@ -1,15 +1,27 @@
 package e2b
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
@@ -17,6 +29,21 @@ import (
 
 var upgrader = websocket.Upgrader{}
 
+// TestMain keeps the tests away from the user's config file and profile.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "e2b-config")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	_ = os.Setenv("XDG_CONFIG_HOME", dir)
+	_ = os.Unsetenv(profileEnv)
+	_ = os.Unsetenv(domainEnv)
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 const subID = "test-sub-id"
 
 func testLogger() *slog.Logger {
@@ -189,3 +216,1684 @@ func TestNewSandbox(t *testing.T) {
 		t.Logf("test got event: %s", string(jsnBytes))
 	}
 }
+
+func TestListSandboxes(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal(http.MethodGet, r.Method)
+		a.Equal(sandboxesRoute, r.URL.Path)
+		a.Equal("owner=ci", r.URL.Query().Get("metadata"))
+		a.Equal("running,paused", r.URL.Query().Get("state"))
+		a.Equal("2", r.URL.Query().Get("limit"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(nextTokenHeader, "next")
+		_, _ = w.Write(encode([]SandboxInfo{
+			{ID: "a", Template: "base", State: SandboxStateRunning},
+			{ID: "b", Template: "other", State: SandboxStatePaused},
+		}))
+	}))
+	defer apiServer.Close()
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL))
+	page, err := c.List(
+		ctx,
+		ListWithMetadata("owner", "ci"),
+		ListWithState(SandboxStateRunning, SandboxStatePaused),
+		ListWithTemplate("base"),
+		ListWithLimit(2),
+	)
+	a.NoError(err)
+	a.Equal("next", page.NextToken)
+	a.Len(page.Sandboxes, 1)
+	a.Equal("a", page.Sandboxes[0].ID)
+}
+
+func TestPauseResumeSandbox(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id := "test-sandbox-id"
+	var paths []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(encode(&Sandbox{ID: id}))
+	}))
+	defer apiServer.Close()
+
+	wsts := httptest.NewServer(http.HandlerFunc(echo(a)))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	opts := []Option{
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+	}
+	sb, err := NewSandbox(ctx, "test-api-key", opts...)
+	a.NoError(err)
+	a.NoError(sb.Pause(ctx))
+
+	sb, err = ResumeSandbox(ctx, id, "test-api-key", opts...)
+	a.NoError(err)
+	a.Equal(id, sb.ID)
+	_, err = sb.Ls(ctx, ".")
+	a.NoError(err)
+	a.Equal([]string{
+		"POST " + sandboxesRoute,
+		"POST " + sandboxesRoute + "/" + id + "/pause",
+		"POST " + sandboxesRoute + "/" + id + "/resume",
+	}, paths)
+}
+
+func TestSandboxTimeout(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id := "test-sandbox-id"
+	var timeouts []int
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Timeout int `json:"timeout"`
+		}
+		a.NoError(json.NewDecoder(r.Body).Decode(&body))
+		timeouts = append(timeouts, body.Timeout)
+		if r.URL.Path == sandboxesRoute {
+			_, _ = w.Write(encode(&Sandbox{ID: id}))
+		}
+	}))
+	defer apiServer.Close()
+
+	wsts := httptest.NewServer(http.HandlerFunc(echo(a)))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	sb, err := NewSandbox(
+		ctx,
+		"test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+		WithTimeout(time.Hour),
+	)
+	a.NoError(err)
+	a.WithinDuration(time.Now().Add(time.Hour), sb.ExpiresAt(), time.Minute)
+
+	a.NoError(sb.SetTimeout(ctx, time.Minute))
+	a.WithinDuration(time.Now().Add(time.Minute), sb.ExpiresAt(), 10*time.Second)
+	a.Equal([]int{3600, 60}, timeouts)
+}
+
+func TestWatchMetrics(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now().Truncate(time.Second)
+	var polls atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("/sandboxes/test-sandbox-id/metrics", r.URL.Path)
+		metrics := make([]SandboxMetrics, polls.Add(1))
+		for i := range metrics {
+			metrics[i] = SandboxMetrics{
+				Timestamp: start.Add(time.Duration(i) * time.Second),
+				MemUsed:   int64(i),
+				MemTotal:  4,
+			}
+		}
+		_, _ = w.Write(encode(metrics))
+	}))
+	defer apiServer.Close()
+
+	sb := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL)).newSandbox()
+	sb.ID = "test-sandbox-id"
+
+	samples, errs := sb.WatchMetrics(ctx, 10*time.Millisecond)
+	for i := range 3 {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		case m := <-samples:
+			a.Equal(int64(i), m.MemUsed)
+			a.InDelta(float64(i)*25, m.MemUsedPct(), 0.001)
+		}
+	}
+	cancel()
+
+	// A zero interval polls at the default interval.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	samples, _ = sb.WatchMetrics(ctx, 0)
+	<-samples
+	cancel()
+
+	// A failed poll does not stop the watch, even while its error is unread.
+	var failed atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !failed.Swap(true) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(encode([]SandboxMetrics{{Timestamp: start, MemUsed: 1, MemTotal: 4}}))
+	}))
+	defer flaky.Close()
+	sb = NewClient("test-api-key", ClientWithBaseURL(flaky.URL)).newSandbox()
+	sb.ID = "test-sandbox-id"
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	samples, errs = sb.WatchMetrics(ctx, 10*time.Millisecond)
+	m := <-samples
+	a.Equal(int64(1), m.MemUsed)
+	var cpErr *ControlPlaneError
+	a.ErrorAs(<-errs, &cpErr)
+}
+
+func TestLogs(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	since := time.UnixMilli(1_700_000_000_000).UTC()
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("/sandboxes/test-sandbox-id/logs", r.URL.Path)
+		a.Equal("1700000000000", r.URL.Query().Get("start"))
+		a.Equal("10", r.URL.Query().Get("limit"))
+		_, _ = w.Write(encode(map[string][]LogEntry{"logEntries": {
+			{Timestamp: since.Add(-time.Second), Message: "old"},
+			{Timestamp: since, Level: "info", Message: "envd started"},
+			{Timestamp: since.Add(time.Second), Level: "error", Message: "oom", Fields: map[string]string{"pid": "1"}},
+		}}))
+	}))
+	defer apiServer.Close()
+
+	sb := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL)).newSandbox()
+	sb.ID = "test-sandbox-id"
+
+	entries, errs := sb.Logs(ctx, LogsOptions{Since: since, Limit: 10})
+	var msgs []string
+	for entry := range entries {
+		msgs = append(msgs, entry.Message)
+	}
+	a.NoError(<-errs)
+	a.Equal([]string{"envd started", "oom"}, msgs)
+
+	// A failed fetch closes the entries and reports the error.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	sb = NewClient("test-api-key", ClientWithBaseURL(failing.URL)).newSandbox()
+	sb.ID = "test-sandbox-id"
+	entries, errs = sb.Logs(ctx, LogsOptions{})
+	for range entries {
+		t.Fatal("unexpected entry")
+	}
+	var cpErr *ControlPlaneError
+	a.ErrorAs(<-errs, &cpErr)
+}
+
+func TestAutoKeepAlive(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id := "test-sandbox-id"
+	var mu sync.Mutex
+	refreshes := 0
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == sandboxesRoute {
+			_, _ = w.Write(encode(&Sandbox{ID: id}))
+			return
+		}
+		if r.Method == http.MethodDelete {
+			return
+		}
+		a.Equal("/sandboxes/"+id+"/refreshes", r.URL.Path)
+		mu.Lock()
+		defer mu.Unlock()
+		refreshes++
+		if refreshes > 2 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer apiServer.Close()
+
+	wsts := httptest.NewServer(http.HandlerFunc(echo(a)))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	failed := make(chan error, 1)
+	sb, err := NewSandbox(
+		ctx,
+		"test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+		WithAutoKeepAlive(10*time.Millisecond, time.Second),
+		WithOnKeepAliveFailure(func(_ *Sandbox, err error) { failed <- err }),
+	)
+	a.NoError(err)
+
+	select {
+	case err := <-failed:
+		a.Error(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("keep-alive failure callback not called")
+	}
+	mu.Lock()
+	a.Equal(3, refreshes)
+	mu.Unlock()
+	a.NoError(sb.Close(ctx))
+}
+
+func TestClient(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	id := "test-sandbox-id"
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("test-api-key", r.Header.Get("X-API-Key"))
+		a.Equal(sandboxesRoute+"/"+id, r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write(encode(&SandboxInfo{ID: id, State: SandboxStateRunning}))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer apiServer.Close()
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger()))
+	info, err := c.Get(ctx, id)
+	a.NoError(err)
+	a.Equal(id, info.ID)
+	a.Equal(SandboxStateRunning, info.State)
+	a.NoError(c.Kill(ctx, id))
+
+	sb := c.newSandbox()
+	WithBaseURL("http://other")(sb)
+	a.Equal("http://other", sb.api.baseURL)
+	a.Equal(apiServer.URL, c.baseURL, "sandbox options must not modify a shared client")
+}
+
+func TestReap(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	old := time.Now().Add(-2 * time.Hour)
+	var mu sync.Mutex
+	var killed []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("nextToken") == "":
+			a.Equal("owner=ci", r.URL.Query().Get("metadata"))
+			w.Header().Set(nextTokenHeader, "page-2")
+			_, _ = w.Write(encode([]SandboxInfo{
+				{ID: "old", StartedAt: old, Metadata: map[string]string{"owner": "ci"}},
+				{ID: "young", StartedAt: time.Now(), Metadata: map[string]string{"owner": "ci"}},
+			}))
+		case r.Method == http.MethodGet:
+			_, _ = w.Write(encode([]SandboxInfo{
+				{ID: "gone", StartedAt: old, Metadata: map[string]string{"owner": "ci"}},
+				{ID: "stuck", StartedAt: old, Metadata: map[string]string{"owner": "ci"}},
+				{ID: "other", StartedAt: old, Metadata: map[string]string{"owner": "dev"}},
+			}))
+		case r.Method == http.MethodDelete:
+			mu.Lock()
+			defer mu.Unlock()
+			id := strings.TrimPrefix(r.URL.Path, deleteSandboxRoute)
+			switch id {
+			case "gone":
+				w.WriteHeader(http.StatusNotFound)
+				return
+			case "stuck":
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			killed = append(killed, id)
+		}
+	}))
+	defer apiServer.Close()
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger()))
+	report, err := c.Reap(ctx, map[string]string{"owner": "ci"}, time.Hour)
+	a.NoError(err)
+	a.Equal([]string{"old"}, killed)
+	a.Len(report.Killed, 1)
+	a.Equal("old", report.Killed[0].ID)
+	a.Len(report.Failed, 1)
+	a.Equal("stuck", report.Failed[0].Sandbox.ID)
+	a.Error(report.Failed[0].Err)
+
+	_, err = c.Reap(ctx, nil, time.Hour)
+	a.Error(err)
+}
+
+func TestFork(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	created := 0
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case sandboxesRoute + "/parent/snapshots":
+			_, _ = w.Write(encode(map[string]string{"snapshotID": "snap"}))
+		case sandboxesRoute:
+			var body Sandbox
+			a.NoError(json.NewDecoder(r.Body).Decode(&body))
+			a.Equal(SandboxTemplate("snap"), body.Template)
+			a.Equal("ci", body.Metadata["owner"])
+			created++
+			_, _ = w.Write(encode(&Sandbox{ID: "fork-" + strconv.Itoa(created), Template: body.Template}))
+		}
+	}))
+	defer apiServer.Close()
+
+	// echo serializes its connections; give every fork its own handler.
+	wsts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echo(a)(w, r)
+	}))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	sb := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger())).newSandbox()
+	sb.ID = "parent"
+	WithMetaData(map[string]string{"owner": "ci"})(sb)
+	WithWsURL(func(_ *Sandbox) string { return u })(sb)
+	WithHeartbeat(time.Minute, time.Minute)(sb)
+	var dials atomic.Int32
+	WithTransport(func(ctx context.Context, s *Sandbox) (Transport, error) {
+		dials.Add(1)
+		return DialWebsocket(ctx, s)
+	})(sb)
+
+	_, err := sb.Fork(ctx, 0)
+	a.Error(err)
+	a.Zero(created)
+
+	forks, err := sb.Fork(ctx, 3)
+	a.NoError(err)
+	a.Len(forks, 3)
+	ids := map[string]bool{}
+	for _, f := range forks {
+		ids[f.ID] = true
+		a.Equal(SandboxTemplate("snap"), f.Template)
+		a.Equal(time.Minute, f.wsOpts.pingInterval)
+	}
+	a.Len(ids, 3)
+	a.EqualValues(3, dials.Load())
+}
+
+func TestSandboxResources(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	var created Sandbox
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case templatesRoute + "/big":
+			_, _ = w.Write(encode(&TemplateInfo{ID: "big", CPUCount: 8, MemoryMB: 8192, DiskSizeMB: 20480}))
+		case sandboxesRoute:
+			a.NoError(json.NewDecoder(r.Body).Decode(&created))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer apiServer.Close()
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger()))
+	_, err := c.Create(ctx, WithTemplate("big"), WithCPUCount(16))
+	var rangeErr ErrResourceOutOfRange
+	a.ErrorAs(err, &rangeErr)
+	a.Equal("cpuCount", rangeErr.Resource)
+	a.Equal(8, rangeErr.Max)
+
+	// Only the upper bound is checked.
+	_, err = c.Create(ctx, WithTemplate("big"), WithMemoryMB(64))
+	a.False(errors.As(err, &rangeErr))
+	a.Equal(64, created.MemoryMB)
+
+	_, err = c.Create(ctx, WithTemplate("big"), WithCPUCount(4), WithMemoryMB(4096))
+	a.Error(err)
+	a.Equal(4, created.CPUCount)
+	a.Equal(4096, created.MemoryMB)
+	a.Zero(created.DiskSizeMB)
+}
+
+func TestSandboxNetworkPolicy(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	var created Sandbox
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.NoError(json.NewDecoder(r.Body).Decode(&created))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer apiServer.Close()
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger()))
+	_, err := c.Create(ctx, WithEgressAllowlist("pypi.internal.example.com", "not a domain"))
+	a.ErrorAs(err, new(ErrInvalidEgressRule))
+
+	_, err = c.Create(ctx, WithInternetAccess(false), WithEgressAllowlist("*.example.com", "10.0.0.0/8", "10.1.2.3"))
+	a.Error(err)
+	a.NotNil(created.Network)
+	a.False(*created.Network.AllowInternetAccess)
+	a.Equal([]string{"*.example.com", "10.0.0.0/8", "10.1.2.3"}, created.Network.AllowOut)
+	a.False(created.Network.InternetAccess())
+}
+
+func TestSecureSandbox(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	token := "test-envd-token"
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body Sandbox
+		a.NoError(json.NewDecoder(r.Body).Decode(&body))
+		a.True(body.Secure)
+		_, _ = w.Write(encode(&Sandbox{ID: "test-sandbox-id", EnvdAccessToken: token}))
+	}))
+	defer apiServer.Close()
+
+	files := map[string]string{}
+	ws := echo(a)
+	envd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(envdAccessTokenHeader) != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == wsRoute:
+			ws(w, r)
+		case r.URL.Path == fileRoute && r.Method == http.MethodPost:
+			f, _, err := r.FormFile("file")
+			a.NoError(err)
+			b, err := io.ReadAll(f)
+			a.NoError(err)
+			files[r.URL.Query().Get("path")] = string(b)
+		case r.URL.Path == fileRoute:
+			_, _ = io.WriteString(w, files[r.URL.Query().Get("path")])
+		}
+	}))
+	defer envd.Close()
+	u := "ws" + strings.TrimPrefix(envd.URL, "http") + wsRoute
+
+	sb, err := NewSandbox(
+		ctx,
+		"test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+		WithSecure(true),
+	)
+	a.NoError(err)
+	a.Equal(token, sb.EnvdAccessToken)
+
+	_, err = sb.Ls(ctx, ".")
+	a.NoError(err)
+
+	a.NoError(sb.UploadFile(ctx, "/home/user/data.csv", strings.NewReader("a,b\n1,2\n")))
+	rc, err := sb.DownloadFile(ctx, "/home/user/data.csv")
+	a.NoError(err)
+	defer func() {
+		_ = rc.Close()
+	}()
+	b, err := io.ReadAll(rc)
+	a.NoError(err)
+	a.Equal("a,b\n1,2\n", string(b))
+}
+
+func TestCustomDomain(t *testing.T) {
+	a := assert.New(t)
+
+	t.Setenv(domainEnv, "")
+	sb := NewClient("test-api-key").newSandbox()
+	sb.ID, sb.ClientID = "id", "client"
+	a.Equal("https://api.e2b.dev", sb.api.apiURL())
+	a.Equal("wss://49982-id-client.e2b.dev/ws", sb.wsURL(sb))
+
+	t.Setenv(domainEnv, "e2b.example.com")
+	sb = NewClient("test-api-key").newSandbox()
+	sb.ID, sb.ClientID = "id", "client"
+	a.Equal("https://api.e2b.example.com", sb.api.apiURL())
+	a.Equal("wss://49982-id-client.e2b.example.com/ws", sb.wsURL(sb))
+	a.Equal("3000-id-client.e2b.example.com", sb.GetHost(3000))
+
+	WithDomain("sandbox.internal")(sb)
+	WithEnvdPort(4000)(sb)
+	WithInsecure(true)(sb)
+	a.Equal("http://api.sandbox.internal", sb.api.apiURL())
+	a.Equal("ws://4000-id-client.sandbox.internal/ws", sb.wsURL(sb))
+	u, err := sb.fileURL("/a.txt")
+	a.NoError(err)
+	a.Equal("http://4000-id-client.sandbox.internal/file?path=%2Fa.txt", u)
+
+	WithBaseURL("http://localhost:3000")(sb)
+	a.Equal("http://localhost:3000", sb.api.apiURL())
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv(apiKeyEnv, "env-key")
+	t.Setenv(domainEnv, "env.example.com")
+	t.Setenv(profileEnv, "")
+
+	// Environment only.
+	cfg, err := LoadConfig("")
+	a.NoError(err)
+	a.Equal(&Config{APIKey: "env-key", Domain: "env.example.com"}, cfg)
+	_, err = LoadConfig("staging")
+	a.Error(err, "an explicit profile must exist")
+
+	// The environment overrides the profile file.
+	a.NoError(os.MkdirAll(filepath.Join(dir, "e2b"), 0o700))
+	a.NoError(os.WriteFile(filepath.Join(dir, configFile), []byte(`
+# e2b profiles
+[default]
+api_key = "file-key" # inline comment
+template = 'file-template'
+timeout = "10m"
+telemetry = true
+
+["staging"]
+domain = "staging.example.com"
+request_timeout = 30
+`), 0o600))
+	cfg, err = LoadConfig("")
+	a.NoError(err)
+	a.Equal(&Config{
+		APIKey:   "env-key",
+		Domain:   "env.example.com",
+		Template: "file-template",
+		Timeout:  10 * time.Minute,
+	}, cfg)
+
+	t.Setenv(profileEnv, "staging")
+	t.Setenv(domainEnv, "")
+	cfg, err = LoadConfig("")
+	a.NoError(err)
+	a.Equal("env-key", cfg.APIKey)
+	a.Equal("staging.example.com", cfg.Domain)
+	a.Equal(30*time.Second, cfg.RequestTimeout)
+
+	// Explicit arguments and options override the profile file.
+	t.Setenv(profileEnv, "")
+	t.Setenv(apiKeyEnv, "")
+	c := NewClient("", ClientWithDomain("explicit.example.com"))
+	a.Equal("file-key", c.apiKey)
+	a.Equal("explicit.example.com", c.domain)
+	sb := c.newSandbox()
+	a.Equal(SandboxTemplate("file-template"), sb.Template)
+	a.Equal(600, sb.Timeout)
+	WithTemplate("explicit-template")(sb)
+	a.Equal(SandboxTemplate("explicit-template"), sb.Template)
+	a.Equal("explicit-key", NewClient("explicit-key").apiKey)
+
+	// A broken file fails the requests needing its api key only.
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(encode(&SandboxInfo{}))
+	}))
+	defer apiServer.Close()
+	a.NoError(os.WriteFile(filepath.Join(dir, configFile), []byte("[default]\ntimeout = \"soon\"\n"), 0o600))
+	_, err = NewClient("").Get(context.Background(), "id")
+	a.ErrorContains(err, "timeout")
+	_, err = NewClient("explicit-key", ClientWithBaseURL(apiServer.URL)).Get(context.Background(), "id")
+	a.NoError(err)
+	t.Setenv(apiKeyEnv, "env-key")
+	_, err = NewClient("", ClientWithBaseURL(apiServer.URL)).Get(context.Background(), "id")
+	a.NoError(err)
+	t.Setenv(profileEnv, "missing")
+	_, err = NewClient("explicit-key", ClientWithBaseURL(apiServer.URL)).Get(context.Background(), "id")
+	a.NoError(err)
+}
+
+func TestControlPlaneError(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(requestIDHeader, "req-1")
+		switch r.URL.Path {
+		case sandboxesRoute + "/gone":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write(encode(APIError{Code: 404, Message: "sandbox gone not found"}))
+		case sandboxesRoute + "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write(encode(APIError{Code: 429, Message: "you have reached the maximum number of concurrent sandboxes"}))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, "invalid api key")
+		}
+	}))
+	defer apiServer.Close()
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL))
+	err := c.Kill(ctx, "gone")
+	a.ErrorIs(err, ErrNotFound)
+	a.NotErrorIs(err, ErrUnauthorized)
+	var cpErr *ControlPlaneError
+	a.ErrorAs(err, &cpErr)
+	a.Equal(http.StatusNotFound, cpErr.StatusCode)
+	a.Equal(404, cpErr.Code)
+	a.Equal("sandbox gone not found", cpErr.Message)
+	a.Equal("req-1", cpErr.RequestID)
+	a.Equal(http.MethodDelete, cpErr.Method)
+
+	_, err = c.Get(ctx, "busy")
+	a.ErrorIs(err, ErrQuotaExceeded)
+	a.NotErrorIs(err, ErrRateLimited)
+
+	_, err = c.Get(ctx, "other")
+	a.ErrorIs(err, ErrUnauthorized)
+	a.ErrorContains(err, "invalid api key")
+}
+
+func TestRetryPolicy(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[r.Method+" "+r.URL.Path]++
+		body, err := io.ReadAll(r.Body)
+		a.NoError(err)
+		if r.Method == http.MethodPost {
+			a.NotEmpty(body, "retried requests must resend their body")
+		}
+		switch n := attempts[r.Method+" "+r.URL.Path]; {
+		case n == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case n == 2:
+			w.WriteHeader(http.StatusBadGateway)
+		case r.URL.Path == sandboxesRoute+"/id/refreshes":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			_, _ = w.Write(encode(&SandboxInfo{ID: "id"}))
+		}
+	}))
+	defer apiServer.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	c := NewClient("test-api-key",
+		ClientWithBaseURL(apiServer.URL),
+		ClientWithLogger(testLogger()),
+		ClientWithRetryPolicy(policy),
+	)
+	info, err := c.Get(ctx, "id")
+	a.NoError(err)
+	a.Equal("id", info.ID)
+
+	sb := c.newSandbox()
+	sb.ID = "id"
+	a.NoError(sb.KeepAlive(ctx, time.Minute))
+
+	_, err = c.Create(ctx)
+	a.ErrorIs(err, ErrRateLimited, "creating a sandbox is not idempotent")
+
+	a.Equal(map[string]int{
+		"GET " + sandboxesRoute + "/id":            3,
+		"POST " + sandboxesRoute + "/id/refreshes": 3,
+		"POST " + sandboxesRoute:                   1,
+	}, attempts)
+
+	// Attempts never outlive the context's deadline.
+	deadlineCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	c = NewClient("test-api-key",
+		ClientWithBaseURL(apiServer.URL),
+		ClientWithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}),
+	)
+	_, err = c.Get(deadlineCtx, "other")
+	a.ErrorIs(err, ErrRateLimited)
+}
+
+func TestSandboxLimits(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var killed atomic.Int32
+	var expiring atomic.Bool
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			sb := &Sandbox{ID: "test-sandbox-id"}
+			if expiring.Load() {
+				sb.EndAt = time.Now().Add(100 * time.Millisecond)
+			}
+			_, _ = w.Write(encode(sb))
+		case http.MethodDelete:
+			killed.Add(1)
+		}
+	}))
+	defer apiServer.Close()
+	wsts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echo(a)(w, r)
+	}))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	c := NewClient("test-api-key",
+		ClientWithBaseURL(apiServer.URL),
+		ClientWithLogger(testLogger()),
+		ClientWithSandboxLimits(1, 100),
+	)
+	opt := WithWsURL(func(_ *Sandbox) string { return u })
+	first, err := c.Create(ctx, opt)
+	a.NoError(err)
+
+	// Over the limit, creation waits for a slot or the context.
+	shortCtx, shortCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer shortCancel()
+	_, err = c.Create(shortCtx, opt)
+	a.ErrorIs(err, context.DeadlineExceeded)
+
+	created := make(chan *Sandbox)
+	go func() {
+		sb, err := c.Create(ctx, opt)
+		a.NoError(err)
+		created <- sb
+	}()
+	select {
+	case <-created:
+		t.Fatal("sandbox created over the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	a.NoError(first.Stop(ctx))
+	var second *Sandbox
+	select {
+	case second = <-created:
+		a.NotNil(second)
+	case <-time.After(time.Second):
+		t.Fatal("stopping a sandbox did not free its slot")
+	}
+
+	// Resumed sandboxes take a slot too.
+	a.NoError(second.Pause(ctx))
+	resumed, err := c.Resume(ctx, second.ID, opt)
+	a.NoError(err)
+	shortCtx, shortCancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer shortCancel()
+	_, err = c.Resume(shortCtx, second.ID, opt)
+	a.ErrorIs(err, context.DeadlineExceeded)
+	a.NoError(resumed.Stop(ctx))
+
+	// A sandbox whose websocket cannot be dialed is killed and frees its
+	// slot.
+	killed.Store(0)
+	_, err = c.Create(ctx, WithWsURL(func(_ *Sandbox) string { return "ws://127.0.0.1:1/ws" }))
+	a.Error(err)
+	a.EqualValues(1, killed.Load())
+	shortCtx, shortCancel = context.WithTimeout(ctx, time.Second)
+	defer shortCancel()
+	sb, err := c.Create(shortCtx, opt)
+	a.NoError(err)
+	a.NoError(sb.Stop(ctx))
+
+	// A sandbox left to expire frees its slot, unless kept alive.
+	expiring.Store(true)
+	sb, err = c.Create(ctx, opt)
+	a.NoError(err)
+	a.NoError(sb.KeepAlive(ctx, 400*time.Millisecond))
+	shortCtx, shortCancel = context.WithTimeout(ctx, 200*time.Millisecond)
+	defer shortCancel()
+	_, err = c.Create(shortCtx, opt)
+	a.ErrorIs(err, context.DeadlineExceeded)
+	shortCtx, shortCancel = context.WithTimeout(ctx, 2*time.Second)
+	defer shortCancel()
+	sb, err = c.Create(shortCtx, opt)
+	a.NoError(err)
+	a.NoError(sb.Stop(ctx))
+}
+
+func TestRateLimiterGiveBack(t *testing.T) {
+	a := assert.New(t)
+	r := newRateLimiter(10)
+	a.NoError(r.wait(context.Background()))
+
+	// A canceled waiter does not delay the next one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	a.ErrorIs(r.wait(ctx), context.DeadlineExceeded)
+	start := time.Now()
+	a.NoError(r.wait(context.Background()))
+	a.Less(time.Since(start), 150*time.Millisecond)
+
+	// The time of a canceled waiter queued ahead of others goes to the
+	// next one.
+	first, second := r.reserve(), r.reserve()
+	r.giveBack(first)
+	a.Equal(first, r.reserve())
+	a.Equal(second.Add(r.interval), r.reserve())
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	sem := newSemaphore(1)
+	a.NoError(sem.acquire(ctx))
+	order := make(chan int, 3)
+	for i := range 3 {
+		go func() {
+			a.NoError(sem.acquire(ctx))
+			order <- i
+			sem.release()
+		}()
+		// Queue the waiters in order.
+		for {
+			sem.mu.Lock()
+			n := sem.waiters.Len()
+			sem.mu.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	sem.release()
+	for i := range 3 {
+		a.Equal(i, <-order)
+	}
+
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for range 3 {
+		a.NoError(limiter.wait(ctx))
+	}
+	a.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+}
+
+func TestPool(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var created, killed, refreshed atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/refreshes"):
+			refreshed.Add(1)
+		case r.Method == http.MethodPost:
+			n := created.Add(1)
+			_, _ = w.Write(encode(&Sandbox{ID: fmt.Sprintf("test-sandbox-%d", n)}))
+		case r.Method == http.MethodDelete:
+			killed.Add(1)
+		}
+	}))
+	defer apiServer.Close()
+	wsts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echo(a)(w, r)
+	}))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger()))
+	var unhealthy, blocked atomic.Value
+	unhealthy.Store("")
+	blocked.Store("")
+	unblock := make(chan struct{})
+	pool := NewPool(c, 2,
+		PoolWithSandboxOptions(WithWsURL(func(_ *Sandbox) string { return u })),
+		PoolWithReset(func(ctx context.Context, s *Sandbox) error {
+			_, err := s.Ls(ctx, "/")
+			return err
+		}),
+		PoolWithHealthCheck(time.Hour, func(_ context.Context, s *Sandbox) error {
+			if s.ID == blocked.Load() {
+				<-unblock
+			}
+			if s.ID == unhealthy.Load() {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}),
+	)
+	idle := func() int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.idle)
+	}
+	a.Eventually(func() bool { return idle() == 2 }, time.Second, time.Millisecond)
+	a.EqualValues(2, created.Load())
+
+	// Acquiring hands out a warm sandbox and refills the pool.
+	sb, err := pool.Acquire(ctx)
+	a.NoError(err)
+	a.NotEmpty(sb.ID)
+	a.Eventually(func() bool { return idle() == 2 }, time.Second, time.Millisecond)
+	a.EqualValues(3, created.Load())
+
+	// Released sandboxes are recycled while the pool is not full, and
+	// killed otherwise.
+	next, err := pool.Acquire(ctx)
+	a.NoError(err)
+	a.NoError(pool.Release(ctx, sb))
+	a.NoError(pool.Release(ctx, next))
+	a.Eventually(func() bool { return created.Load()-killed.Load() == 2 }, time.Second, time.Millisecond)
+	a.Equal(2, idle())
+
+	// Idle sandboxes about to expire are kept alive, or evicted once too
+	// close to expiring.
+	pool.mu.Lock()
+	stale := pool.idle[0]
+	pool.mu.Unlock()
+	stale.setExpiresAt(time.Now().Add(poolRefreshMargin - time.Second))
+	pool.check(ctx, false)
+	a.EqualValues(1, refreshed.Load())
+	a.Greater(time.Until(stale.ExpiresAt()), poolRefreshMargin)
+	a.Equal(2, idle())
+	stale.setExpiresAt(time.Now().Add(poolExpiryMargin - time.Second))
+	k := killed.Load()
+	pool.check(ctx, false)
+	a.Eventually(func() bool { return killed.Load() == k+1 }, time.Second, time.Millisecond)
+	a.Eventually(func() bool { return idle() == 2 }, time.Second, time.Millisecond)
+
+	// Checks leave the other idle sandboxes available.
+	pool.mu.Lock()
+	blocked.Store(pool.idle[1].ID)
+	pool.mu.Unlock()
+	checked := make(chan struct{})
+	go func() {
+		pool.check(ctx, true)
+		close(checked)
+	}()
+	a.Eventually(func() bool { return idle() == 1 }, time.Second, time.Millisecond)
+	close(unblock)
+	<-checked
+	a.Equal(2, idle())
+
+	// Unhealthy sandboxes are killed rather than handed out.
+	pool.mu.Lock()
+	unhealthy.Store(pool.idle[0].ID)
+	pool.mu.Unlock()
+	sb, err = pool.Acquire(ctx)
+	a.NoError(err)
+	a.NotEqual(unhealthy.Load(), sb.ID)
+	a.Eventually(func() bool { return killed.Load() == k+2 }, time.Second, time.Millisecond)
+	a.NoError(sb.Stop(ctx))
+
+	a.Eventually(func() bool { return idle() == 2 }, time.Second, time.Millisecond)
+	a.NoError(pool.Close(ctx))
+	a.EqualValues(created.Load(), killed.Load())
+	_, err = pool.Acquire(ctx)
+	a.ErrorIs(err, ErrPoolClosed)
+
+	// A zero health check interval only checks on Acquire.
+	pool = NewPool(c, 0, PoolWithHealthCheck(0, func(context.Context, *Sandbox) error { return nil }))
+	a.NoError(pool.Close(ctx))
+}
+
+func TestBuildTemplate(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	a.NoError(os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM e2bdev/code-interpreter\n"), 0o644))
+	a.NoError(os.MkdirAll(filepath.Join(dir, "app"), 0o755))
+	a.NoError(os.WriteFile(filepath.Join(dir, "app", "main.py"), []byte("print(1)\n"), 0o644))
+
+	var (
+		apiURL   string
+		uploaded []string
+		hash     string
+		status   atomic.Value
+	)
+	status.Store(BuildStatusReady)
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == templatesRoute:
+			var body map[string]any
+			a.NoError(json.NewDecoder(r.Body).Decode(&body))
+			a.Equal("FROM e2bdev/code-interpreter\n", body["dockerfile"])
+			a.Equal("my-template", body["alias"])
+			a.EqualValues(2, body["cpuCount"])
+			_, _ = w.Write(encode(templateBuild{TemplateID: "tpl", BuildID: "b1"}))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, templatesRoute+"/tpl/files/"):
+			hash = strings.TrimPrefix(r.URL.Path, templatesRoute+"/tpl/files/")
+			_, _ = w.Write(encode(map[string]any{"present": false, "url": apiURL + "/upload"}))
+		case r.Method == http.MethodPut && r.URL.Path == "/upload":
+			gr, err := gzip.NewReader(r.Body)
+			a.NoError(err)
+			tr := tar.NewReader(gr)
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					break
+				}
+				uploaded = append(uploaded, hdr.Name)
+			}
+		case r.Method == http.MethodPost && r.URL.Path == templatesRoute+"/tpl/builds/b1":
+			var body map[string]string
+			a.NoError(json.NewDecoder(r.Body).Decode(&body))
+			a.Equal(hash, body["contextHash"])
+		case r.Method == http.MethodGet && r.URL.Path == templatesRoute+"/tpl/builds/b1/status":
+			a.Equal("0", r.URL.Query().Get("logsOffset"))
+			_, _ = w.Write(encode(templateBuild{
+				Status: status.Load().(BuildStatus),
+				Reason: "bad step",
+				Logs:   []BuildLog{{Message: "step 1/1"}, {Message: "done"}},
+			}))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer apiServer.Close()
+	apiURL = apiServer.URL
+
+	c := NewClient("test-api-key", ClientWithBaseURL(apiServer.URL), ClientWithLogger(testLogger()))
+	logs := make(chan BuildLog, 10)
+	template, err := c.BuildTemplate(ctx, BuildSpec{
+		ContextDir: dir,
+		CPU:        2,
+		Alias:      "my-template",
+		Logs:       logs,
+	})
+	a.NoError(err)
+	a.Equal(SandboxTemplate("tpl"), template)
+	a.ElementsMatch([]string{"Dockerfile", "app", "app/main.py"}, uploaded)
+	a.Len(logs, 2)
+	a.Equal("step 1/1", (<-logs).Message)
+
+	// A failed build returns no template.
+	status.Store(BuildStatusError)
+	template, err = c.BuildTemplate(ctx, BuildSpec{ContextDir: dir, CPU: 2, Alias: "my-template"})
+	a.ErrorContains(err, "bad step")
+	a.Empty(template)
+
+	// An unchanged context hashes the same.
+	first, err := tarDir(dir)
+	a.NoError(err)
+	second, err := tarDir(dir)
+	a.NoError(err)
+	a.Equal(first, second)
+}
+
+// dialWSTransport dials the websocket server and returns a connection to it.
+func dialWSTransport(t testing.TB, handler http.HandlerFunc, opts wsOptions) *wsTransport {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newWSTransport(ws, nil, testLogger(), opts)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func TestRPCDispatch(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	const calls = 200
+
+	conn := dialWSTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		var batch []Request
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			req := decode(data)
+			switch req.Method {
+			case processSubscribe:
+				// Notifications right behind the response are not missed.
+				a.NoError(c.WriteJSON(Response[string, string]{ID: req.ID, Result: subID}))
+				for i := range 100 {
+					a.NoError(c.WriteJSON(Event{Params: EventParams{
+						Subscription: subID,
+						Result:       EventResult{Line: strconv.Itoa(i)},
+					}}))
+				}
+			case filesystemList:
+				batch = append(batch, req)
+				if len(batch) < calls {
+					continue
+				}
+				// Unclaimed notifications do not stall the connection.
+				for range 50 {
+					a.NoError(c.WriteJSON(Event{Params: EventParams{Subscription: "unknown"}}))
+				}
+				// Responses out of order, each followed by a late duplicate.
+				for _, req := range slices.Backward(batch) {
+					res := Response[[]LsResult, string]{ID: req.ID, Result: []LsResult{{Name: req.Params[0].(string)}}}
+					a.NoError(c.WriteJSON(res))
+					a.NoError(c.WriteJSON(res))
+				}
+				batch = nil
+			}
+		}
+	}, wsOptions{})
+
+	var wg sync.WaitGroup
+	for i := range calls {
+		wg.Go(func() {
+			path := strconv.Itoa(i)
+			msg, err := conn.Call(ctx, filesystemList, []any{path})
+			if !a.NoError(err) {
+				return
+			}
+			res, err := decodeResponse[[]LsResult, string](msg)
+			a.NoError(err)
+			a.Equal([]LsResult{{Name: path}}, res.Result)
+		})
+	}
+	wg.Wait()
+
+	sub, err := conn.Subscribe(ctx, processSubscribe, []any{OnStdout, "id"})
+	a.NoError(err)
+	a.Equal(subID, sub.ID())
+	for i := range 100 {
+		msg, err := sub.Next(ctx)
+		a.NoError(err)
+		event, err := newEvent(msg)
+		a.NoError(err)
+		a.Equal(strconv.Itoa(i), event.Params.Result.Line)
+	}
+	sub.Close()
+	_, err = sub.Next(ctx)
+	a.ErrorIs(err, errUnsubscribed)
+
+	// Closing the connection fails the pending calls.
+	a.NoError(conn.Close())
+	_, err = conn.Call(ctx, filesystemList, []any{"/"})
+	a.ErrorIs(err, errConnClosed)
+}
+
+func BenchmarkRPCConcurrentCalls(b *testing.B) {
+	a := assert.New(b)
+	for _, concurrency := range []int{1, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			sb := &Sandbox{conn: dialWSTransport(b, echo(a), wsOptions{})}
+			ctx := context.Background()
+			b.SetParallelism(max(concurrency/runtime.GOMAXPROCS(0), 1))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := sb.Ls(ctx, "/"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestConcurrentSandboxTraffic(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(encode(&Sandbox{ID: "test-sandbox-id"}))
+	}))
+	defer apiServer.Close()
+	wsts := httptest.NewServer(http.HandlerFunc(echo(a)))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	sb, err := NewSandbox(ctx, "test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+	)
+	a.NoError(err)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			_, err := sb.Ls(ctx, "/")
+			a.NoError(err)
+			a.NoError(sb.Mkdir(ctx, "dir"))
+			a.NoError(sb.Write(ctx, "hello.txt", []byte("hello")))
+			content, err := sb.Read(ctx, "hello.txt")
+			a.NoError(err)
+			a.Equal("hello", content)
+		})
+		wg.Go(func() {
+			proc, err := sb.NewProcess("echo hello")
+			a.NoError(err)
+			a.NoError(proc.Start(ctx))
+			subCtx, subCancel := context.WithCancel(ctx)
+			defer subCancel()
+			events, errs := proc.SubscribeStdout(subCtx)
+			select {
+			case event := <-events:
+				a.Equal("hello", event.Params.Result.Line)
+			case err := <-errs:
+				a.NoError(err)
+			case <-time.After(5 * time.Second):
+				t.Error("no process event")
+			}
+		})
+	}
+	wg.Wait()
+	a.NoError(sb.conn.Close())
+}
+
+func TestRPCWriter(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+
+	// Control messages are written ahead of the queued ones.
+	methods := make(chan Method, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			methods <- decode(data).Method
+		}
+	}))
+	defer ts.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	a.NoError(err)
+	conn := &wsTransport{opts: wsOptions{writeTimeout: time.Second}}
+	l := &link{
+		ws:       ws,
+		writes:   make(chan *writeOp, 3),
+		controls: make(chan *writeOp, 1),
+		down:     make(chan struct{}),
+	}
+	var ops []*writeOp
+	for _, queue := range []chan *writeOp{l.writes, l.writes, l.writes, l.controls} {
+		method := filesystemList
+		if queue == l.controls {
+			method = processUnsubscribe
+		}
+		op := &writeOp{ctx: ctx, data: encode(Request{Method: method}), err: make(chan error, 1)}
+		queue <- op
+		ops = append(ops, op)
+	}
+	go conn.write(l)
+	for _, op := range ops {
+		a.NoError(<-op.err)
+	}
+	a.Equal(processUnsubscribe, <-methods)
+	for range 3 {
+		a.Equal(filesystemList, <-methods)
+	}
+	close(l.down)
+	_ = ws.Close()
+
+	// A write timing out closes the connection instead of blocking.
+	conn = dialWSTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-r.Context().Done()
+		_ = c.Close()
+	}, wsOptions{writeTimeout: 50 * time.Millisecond})
+	err = conn.enqueue(ctx, conn.link, conn.link.writes, Request{Method: filesystemWrite, Params: []any{strings.Repeat("x", 16<<20)}})
+	var netErr net.Error
+	a.True(errors.As(err, &netErr) && netErr.Timeout(), "want a timeout, got %v", err)
+	<-conn.done
+	_, err = conn.Call(ctx, filesystemList, []any{"/"})
+	a.ErrorIs(err, ErrConnectionLost)
+}
+
+func TestReconnect(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(encode(&Sandbox{ID: "test-sandbox-id"}))
+	}))
+	defer apiServer.Close()
+	var dials atomic.Int32
+	wsts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		n := dials.Add(1)
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			req := decode(data)
+			switch req.Method {
+			case processStart:
+				a.NoError(c.WriteJSON(Response[string, APIError]{ID: req.ID, Result: req.Params[0].(string)}))
+			case processSubscribe:
+				id := fmt.Sprintf("sub-%d", n)
+				a.NoError(c.WriteJSON(Response[string, string]{ID: req.ID, Result: id}))
+				a.NoError(c.WriteJSON(Event{Params: EventParams{
+					Subscription: id,
+					Result:       EventResult{Line: fmt.Sprintf("line from connection %d", n)},
+				}}))
+			case filesystemList:
+				if n == 1 {
+					// Drop the connection with the call in flight.
+					return
+				}
+				a.NoError(c.WriteJSON(Response[[]LsResult, string]{ID: req.ID, Result: []LsResult{}}))
+			}
+		}
+	}))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+
+	sb, err := NewSandbox(ctx, "test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+		WithReconnectPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}),
+	)
+	a.NoError(err)
+	proc, err := sb.NewProcess("tail -f log")
+	a.NoError(err)
+	a.NoError(proc.Start(ctx))
+	events, _ := proc.SubscribeStdout(ctx)
+	a.Equal("line from connection 1", (<-events).Params.Result.Line)
+
+	_, err = sb.Ls(ctx, "/")
+	a.ErrorIs(err, ErrConnectionLost)
+
+	// The subscription is resumed on the new connection.
+	select {
+	case event := <-events:
+		a.Equal("line from connection 2", event.Params.Result.Line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not resumed")
+	}
+	_, err = sb.Ls(ctx, "/")
+	a.NoError(err)
+	a.EqualValues(2, dials.Load())
+	a.NoError(sb.conn.Close())
+
+	// A subscription closed while being resumed is unsubscribed from envd
+	// instead of registered again.
+	unsubscribed := make(chan []any, 1)
+	conn := dialWSTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			req := decode(data)
+			switch req.Method {
+			case processSubscribe:
+				a.NoError(c.WriteJSON(Response[string, string]{ID: req.ID, Result: "sub-2"}))
+			case processUnsubscribe:
+				unsubscribed <- req.Params
+				a.NoError(c.WriteJSON(Response[bool, string]{ID: req.ID, Result: true}))
+			}
+		}
+	}, wsOptions{})
+	sub := &subscription{conn: conn, id: "sub-1", method: processSubscribe, ready: make(chan struct{}, 1)}
+	sub.Close()
+	_, err = conn.roundTrip(ctx, &pendingCall{method: processSubscribe, subscribe: true, resume: sub})
+	a.NoError(err)
+	select {
+	case params := <-unsubscribed:
+		a.Equal([]any{"sub-2"}, params)
+	case <-time.After(5 * time.Second):
+		t.Fatal("closed subscription not unsubscribed")
+	}
+	conn.mu.Lock()
+	a.Empty(conn.subs)
+	conn.mu.Unlock()
+}
+
+func TestHeartbeat(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	opts := wsOptions{pingInterval: 20 * time.Millisecond, pongTimeout: 50 * time.Millisecond}
+
+	// A peer answering pings keeps the connection alive.
+	conn := dialWSTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}, opts)
+	sb := &Sandbox{conn: conn}
+	select {
+	case <-sb.Done():
+		t.Fatalf("live connection closed: %v", sb.Err())
+	case <-time.After(300 * time.Millisecond):
+	}
+	a.NoError(sb.Err())
+
+	// A peer that stopped answering, as over a half-open connection, is
+	// detected.
+	conn = dialWSTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-r.Context().Done()
+		_ = c.Close()
+	}, opts)
+	sb = &Sandbox{conn: conn}
+	select {
+	case <-sb.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead connection not detected")
+	}
+	a.ErrorIs(sb.Err(), ErrConnectionLost)
+	a.ErrorContains(sb.Err(), "no heartbeat")
+	_, err := sb.Ls(ctx, "/")
+	a.ErrorIs(err, ErrConnectionLost)
+}
+
+// memTransport is an in-memory transport answering calls from a table of
+// results and feeding its events to every subscription.
+type memTransport struct {
+	results map[Method]any
+	events  chan *Message
+	done    chan struct{}
+	close   sync.Once
+}
+
+func (m *memTransport) Call(_ context.Context, method Method, _ []any) (*Message, error) {
+	if err := m.Err(); err != nil {
+		return nil, err
+	}
+	result, err := json.Marshal(m.results[method])
+	return &Message{Result: result}, err
+}
+
+func (m *memTransport) Subscribe(ctx context.Context, method Method, params []any) (Subscription, error) {
+	if _, err := m.Call(ctx, method, params); err != nil {
+		return nil, err
+	}
+	return memSubscription{m}, nil
+}
+
+func (m *memTransport) Close() error {
+	m.close.Do(func() { close(m.done) })
+	return nil
+}
+
+func (m *memTransport) Done() <-chan struct{} { return m.done }
+
+func (m *memTransport) Err() error {
+	select {
+	case <-m.done:
+		return errConnClosed
+	default:
+		return nil
+	}
+}
+
+type memSubscription struct{ m *memTransport }
+
+func (s memSubscription) ID() string { return subID }
+
+func (s memSubscription) Next(ctx context.Context) (*Message, error) {
+	select {
+	case msg := <-s.m.events:
+		return msg, nil
+	case <-s.m.done:
+		return nil, errConnClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s memSubscription) Close() {}
+
+// recordingTransport records the methods called through a transport.
+type recordingTransport struct {
+	Transport
+	mu    sync.Mutex
+	calls []Method
+}
+
+func (r *recordingTransport) Call(ctx context.Context, method Method, params []any) (*Message, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, method)
+	r.mu.Unlock()
+	return r.Transport.Call(ctx, method, params)
+}
+
+func TestTransport(t *testing.T) {
+	a := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(encode(&Sandbox{ID: "test-sandbox-id"}))
+	}))
+	defer apiServer.Close()
+
+	// An in-memory transport replaces the websocket.
+	var dials atomic.Int32
+	results := map[Method]any{
+		filesystemList:      []LsResult{{Name: "hello.txt"}},
+		filesystemSubscribe: subID,
+	}
+	events := make(chan *Message, 1)
+	sb, err := NewSandbox(ctx, "test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithTransport(func(_ context.Context, _ *Sandbox) (Transport, error) {
+			dials.Add(1)
+			return &memTransport{results: results, events: events, done: make(chan struct{})}, nil
+		}),
+	)
+	a.NoError(err)
+	ls, err := sb.Ls(ctx, "/")
+	a.NoError(err)
+	a.Equal([]LsResult{{Name: "hello.txt"}}, ls)
+
+	fsEvents := make(chan Event)
+	a.NoError(sb.Watch(ctx, "/tmp", fsEvents))
+	events <- &Message{Params: &MessageParams{
+		Subscription: subID,
+		Result:       json.RawMessage(`{"type":"Create","path":"/tmp/a.txt","name":"a.txt"}`),
+	}}
+	a.Equal("/tmp/a.txt", (<-fsEvents).Path)
+
+	// Reconnecting swaps the transport under concurrent readers.
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 100 {
+			_ = sb.Err()
+			_ = sb.Done()
+		}
+	})
+	a.NoError(sb.Reconnect(ctx))
+	wg.Wait()
+	a.EqualValues(2, dials.Load())
+	a.NoError(sb.Err())
+	a.NoError(sb.Stop(ctx))
+	<-sb.Done()
+	a.ErrorIs(sb.Err(), errConnClosed)
+
+	// A sandbox that never connected is done.
+	sb, err = NewSandbox(ctx, "test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithTransport(func(context.Context, *Sandbox) (Transport, error) {
+			return nil, errors.New("unreachable")
+		}),
+	)
+	a.Error(err)
+	<-sb.Done()
+	a.ErrorIs(sb.Err(), ErrNotConnected)
+	_, err = sb.Ls(ctx, "/")
+	a.ErrorIs(err, ErrNotConnected)
+	proc, err := sb.NewProcess("echo")
+	a.NoError(err)
+	_, errs := proc.SubscribeStdout(ctx)
+	a.ErrorIs(<-errs, ErrNotConnected)
+
+	// A transport can wrap the websocket.
+	wsts := httptest.NewServer(http.HandlerFunc(echo(a)))
+	defer wsts.Close()
+	u := "ws" + strings.TrimPrefix(wsts.URL, "http") + "/ws"
+	rec := &recordingTransport{}
+	sb, err = NewSandbox(ctx, "test-api-key",
+		WithLogger(testLogger()),
+		WithBaseURL(apiServer.URL),
+		WithWsURL(func(_ *Sandbox) string { return u }),
+		WithTransport(func(ctx context.Context, s *Sandbox) (Transport, error) {
+			ws, err := DialWebsocket(ctx, s)
+			rec.Transport = ws
+			return rec, err
+		}),
+	)
+	a.NoError(err)
+	_, err = sb.Ls(ctx, "/")
+	a.NoError(err)
+	a.Equal([]Method{filesystemList}, rec.calls)
+	a.NoError(sb.conn.Close())
+}
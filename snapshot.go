@@ -0,0 +1,100 @@
+package e2b
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Snapshot captures the sandbox's file system and memory state.
+//
+// The returned template can be passed to WithTemplate to start new
+// sandboxes from the captured state; the sandbox itself keeps running.
+func (s *Sandbox) Snapshot(ctx context.Context) (SandboxTemplate, error) {
+	req, err := s.api.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s%s/%s/snapshots", s.api.apiURL(), sandboxesRoute, s.ID), struct{}{})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		SnapshotID string `json:"snapshotID"`
+	}
+	err = s.api.sendRequest(req, &res)
+	if err != nil {
+		return "", err
+	}
+	if res.SnapshotID == "" {
+		return "", fmt.Errorf("snapshot of sandbox %s failed: got empty snapshot id", s.ID)
+	}
+	return SandboxTemplate(res.SnapshotID), nil
+}
+
+// Fork snapshots the sandbox and creates n connected sandboxes from the
+// snapshot.
+//
+// The forks inherit the sandbox's metadata, timeout, environment variables,
+// resources, network policy, working directory, logger, transport and
+// keep-alive configuration. If any fork fails to start, every fork created
+// is killed and the errors are returned.
+func (s *Sandbox) Fork(ctx context.Context, n int) ([]*Sandbox, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("fork of sandbox %s failed: invalid fork count %d", s.ID, n)
+	}
+	tmpl, err := s.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inherit := func(f *Sandbox) {
+		f.Template = tmpl
+		f.Cwd = s.Cwd
+		f.Metadata = maps.Clone(s.Metadata)
+		f.Timeout = s.Timeout
+		f.Secure = s.Secure
+		f.EnvVars = maps.Clone(s.EnvVars)
+		f.CPUCount = s.CPUCount
+		f.MemoryMB = s.MemoryMB
+		f.DiskSizeMB = s.DiskSizeMB
+		if s.Network != nil {
+			f.Network = &NetworkPolicy{
+				AllowInternetAccess: s.Network.AllowInternetAccess,
+				AllowOut:            slices.Clone(s.Network.AllowOut),
+			}
+		}
+		f.logger = s.logger
+		f.wsURL = s.wsURL
+		f.transport = s.transport
+		f.wsOpts = s.wsOpts
+		f.keepAlive = keepAlive{
+			interval:  s.keepAlive.interval,
+			extension: s.keepAlive.extension,
+			onFailure: s.keepAlive.onFailure,
+		}
+	}
+	forks := make([]*Sandbox, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forks[i], errs[i] = s.api.Create(ctx, inherit)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		for i, f := range forks {
+			if errs[i] != nil {
+				// Create cleaned up after itself.
+				continue
+			}
+			if kerr := f.Stop(context.WithoutCancel(ctx)); kerr != nil {
+				s.logger.Error("failed to kill fork", "sandbox", f.ID, "error", kerr)
+			}
+		}
+		return nil, fmt.Errorf("fork of sandbox %s failed: %w", s.ID, err)
+	}
+	return forks, nil
+}
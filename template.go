@@ -0,0 +1,66 @@
+package e2b
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// TemplateInfo is a sandbox template as reported by the control plane.
+type TemplateInfo struct {
+	ID         SandboxTemplate `json:"templateID"` // ID of the template.
+	BuildID    string          `json:"buildID"`    // BuildID is the id of the template's last build.
+	Aliases    []string        `json:"aliases"`    // Aliases of the template.
+	Public     bool            `json:"public"`     // Public is true if the template is usable by every team.
+	CPUCount   int             `json:"cpuCount"`   // CPUCount is the maximum number of vCPUs of the template's sandboxes.
+	MemoryMB   int             `json:"memoryMB"`   // MemoryMB is the maximum memory of the template's sandboxes in MiB.
+	DiskSizeMB int             `json:"diskSizeMB"` // DiskSizeMB is the maximum disk size of the template's sandboxes in MiB.
+}
+
+const (
+	templatesRoute = "/templates" // (GET/POST /templates)
+)
+
+// GetTemplate returns the control plane's information about a template.
+func (c *Client) GetTemplate(ctx context.Context, template SandboxTemplate) (*TemplateInfo, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s%s/%s", c.apiURL(), templatesRoute, template), nil)
+	if err != nil {
+		return nil, err
+	}
+	var info TemplateInfo
+	err = c.sendRequest(req, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// validateResources checks the sandbox's requested resources against the
+// maximum allowed by its template: sandboxes can be sized up to the
+// template's resources.
+//
+// The control plane reports no minimum, so only the upper bound is checked
+// and the control plane rejects resources too small for the template.
+func (s *Sandbox) validateResources(ctx context.Context) error {
+	if s.CPUCount == 0 && s.MemoryMB == 0 && s.DiskSizeMB == 0 {
+		return nil
+	}
+	info, err := s.api.GetTemplate(ctx, s.Template)
+	if err != nil {
+		return fmt.Errorf("failed to get template %s: %w", s.Template, err)
+	}
+	for _, r := range []ErrResourceOutOfRange{
+		{Resource: "cpuCount", Value: s.CPUCount, Max: info.CPUCount},
+		{Resource: "memoryMB", Value: s.MemoryMB, Max: info.MemoryMB},
+		{Resource: "diskSizeMB", Value: s.DiskSizeMB, Max: info.DiskSizeMB},
+	} {
+		if r.Value == 0 {
+			continue
+		}
+		if r.Value < 0 || (r.Max > 0 && r.Value > r.Max) {
+			r.Template = s.Template
+			return r
+		}
+	}
+	return nil
+}
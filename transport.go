@@ -7,8 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-
-	"github.com/gorilla/websocket"
+	"time"
 )
 
 type (
@@ -30,6 +29,64 @@ type (
 		Error  Q   `json:"error"`  // Error of the message.
 	}
 
+	// Transport carries the JSON-RPC calls and subscriptions of a sandbox to
+	// its envd.
+	//
+	// The default transport is a websocket, see DialWebsocket; WithTransport
+	// plugs in another one, such as an in-memory transport for tests, a
+	// recorded replay, or a proxy speaking another protocol. A transport
+	// must be safe for concurrent use.
+	Transport interface {
+		// Call calls a method and returns its response.
+		Call(ctx context.Context, method Method, params []any) (*Message, error)
+		// Subscribe calls a method opening a subscription and returns it.
+		//
+		// No notification following the method's response may be missed.
+		Subscribe(ctx context.Context, method Method, params []any) (Subscription, error)
+		// Close closes the transport; its calls and subscriptions fail.
+		Close() error
+		// Done returns a channel closed once the transport is closed or
+		// lost for good.
+		Done() <-chan struct{}
+		// Err returns why the transport is done, or nil if it is not. A
+		// lost transport matches ErrConnectionLost.
+		Err() error
+	}
+
+	// TransportDialer dials the transport of a sandbox once the control
+	// plane has responded.
+	TransportDialer func(ctx context.Context, s *Sandbox) (Transport, error)
+
+	// Subscription is a subscription opened by a Transport.
+	Subscription interface {
+		// ID returns the id of the subscription, which unsubscribes from
+		// envd.
+		ID() string
+		// Next returns the next notification, waiting for it until the
+		// subscription ends or the context is canceled.
+		Next(ctx context.Context) (*Message, error)
+		// Close stops the subscription's notifications without
+		// unsubscribing from envd.
+		Close()
+	}
+
+	// Message is a JSON-RPC response or notification received from envd.
+	//
+	// Its raw result, error and params are decoded into their types by the
+	// receiver.
+	Message struct {
+		ID     int             `json:"id"`     // ID of the request, or zero for a notification.
+		Result json.RawMessage `json:"result"` // Result of the response.
+		Error  json.RawMessage `json:"error"`  // Error of the response.
+		Params *MessageParams  `json:"params"` // Params of the notification.
+	}
+
+	// MessageParams are the params of a JSON-RPC notification.
+	MessageParams struct {
+		Subscription string          `json:"subscription"` // Subscription is the id of the notification's subscription.
+		Result       json.RawMessage `json:"result"`       // Result of the notification.
+	}
+
 	// APIError is the error of the API.
 	APIError struct {
 		Code    int    `json:"code,omitempty"` // Code is the code of the error.
@@ -41,7 +98,13 @@ const (
 	rpc = "2.0"
 )
 
-func (s *Sandbox) newRequest(ctx context.Context, method, url string, body any) (*http.Request, error) {
+func (c *Client) newRequest(ctx context.Context, method, url string, body any) (*http.Request, error) {
+	if c.apiKey == "" && c.err != nil {
+		return nil, fmt.Errorf("no e2b api key: failed to load e2b config: %w", c.err)
+	}
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("no e2b api key: pass one explicitly, set %s or add it to the config file", apiKeyEnv)
+	}
 	var reqBody io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
@@ -54,24 +117,75 @@ func (s *Sandbox) newRequest(ctx context.Context, method, url string, body any)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("X-API-Key", s.apiKey)
+	req.Header.Set("X-API-Key", c.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	return req, nil
 }
 
-func (s *Sandbox) sendRequest(req *http.Request, v interface{}) error {
-	res, err := s.client.Do(req)
+// do sends the request to the control plane, retrying idempotent requests
+// according to the client's retry policy.
+//
+// A response with an error status is returned as a *ControlPlaneError;
+// otherwise the caller must close the response's body.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(req) {
+		attempts = max(c.retry.MaxAttempts, 1)
+	}
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		res, err := c.doOnce(req)
+		if err == nil || attempt >= attempts || !retryable(ctx, err) {
+			return res, err
+		}
+		wait := c.retry.backoff(attempt, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err
+		}
+		c.logger.Warn("retrying e2b request",
+			"method", req.Method,
+			"path", req.URL.Path,
+			"attempt", attempt,
+			"wait", wait,
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		req, err = rewind(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// doOnce sends the request to the control plane once.
+func (c *Client) doOnce(req *http.Request) (*http.Response, error) {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < http.StatusOK ||
+		res.StatusCode >= http.StatusBadRequest {
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		return nil, newControlPlaneError(req, res)
+	}
+	return res, nil
+}
+
+func (c *Client) sendRequest(req *http.Request, v interface{}) error {
+	res, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		_ = res.Body.Close()
 	}()
-	if res.StatusCode < http.StatusOK ||
-		res.StatusCode >= http.StatusBadRequest {
-		return fmt.Errorf("failed to create sandbox: %s", res.Status)
-	}
 	if v == nil {
 		return nil
 	}
@@ -88,122 +202,20 @@ func (s *Sandbox) sendRequest(req *http.Request, v interface{}) error {
 	}
 }
 
-func decodeResponse[T any, Q any](body []byte) (*Response[T, Q], error) {
-	decResp := new(Response[T, Q])
-	err := json.Unmarshal(body, decResp)
-	if err != nil {
-		return nil, err
-	}
-	return decResp, nil
-}
-
-func (s *Sandbox) read(ctx context.Context) error {
-	var body []byte
-	var err error
-	type decResp struct {
-		Method string `json:"method"`
-		ID     int    `json:"id"`
-		Params struct {
-			Subscription string `json:"subscription"`
-		}
-	}
-	defer func() {
-		err := s.ws.Close()
+// decodeResponse decodes the result and error of a response.
+func decodeResponse[T any, Q any](msg *Message) (*Response[T, Q], error) {
+	res := &Response[T, Q]{ID: msg.ID}
+	if len(msg.Result) > 0 {
+		err := json.Unmarshal(msg.Result, &res.Result)
 		if err != nil {
-			s.logger.Error("failed to close sandbox", "error", err)
-		}
-	}()
-	msgCh := make(chan []byte, 10)
-	for {
-		select {
-		case body = <-msgCh:
-			var decResp decResp
-			err = json.Unmarshal(body, &decResp)
-			if err != nil {
-				return err
-			}
-			var key any
-			key = decResp.Params.Subscription
-			if decResp.ID != 0 {
-				key = decResp.ID
-			}
-			toR, ok := s.Map.Load(key)
-			if !ok {
-				msgCh <- body
-				continue
-			}
-			toRCh, ok := toR.(chan []byte)
-			if !ok {
-				msgCh <- body
-				continue
-			}
-			s.logger.Debug("read",
-				"subscription", decResp.Params.Subscription,
-				"body", body,
-				"sandbox", s.ID,
-			)
-			toRCh <- body
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			_, msg, err := s.ws.ReadMessage()
-			if err != nil {
-				return err
-			}
-			msgCh <- msg
+			return nil, err
 		}
 	}
-}
-
-func (s *Sandbox) writeRequest(
-	ctx context.Context,
-	method Method,
-	params []any,
-	respCh chan []byte,
-) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case id := <-s.idCh:
-		req := Request{
-			Method:  method,
-			JSONRPC: rpc,
-			Params:  params,
-			ID:      id,
-		}
-		s.logger.Debug("request",
-			"sandbox", id,
-			"method", method,
-			"id", id,
-			"params", params,
-		)
-		s.Map.Store(req.ID, respCh)
-		jsVal, err := json.Marshal(req)
-		if err != nil {
-			return err
-		}
-		err = s.ws.WriteMessage(websocket.TextMessage, jsVal)
+	if len(msg.Error) > 0 {
+		err := json.Unmarshal(msg.Error, &res.Error)
 		if err != nil {
-			return fmt.Errorf(
-				"writing %s request failed (%d): %w",
-				method,
-				req.ID,
-				err,
-			)
-		}
-		return nil
-	}
-}
-
-func (s *Sandbox) identify(ctx context.Context) {
-	id := 1
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			s.idCh <- id
-			id++
+			return nil, err
 		}
 	}
+	return res, nil
 }
@@ -0,0 +1,700 @@
+package e2b
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type (
+	// wsTransport is the websocket transport, the default Transport.
+	//
+	// The transport runs over a link, one websocket connection, which is
+	// redialed when lost: the calls in flight fail with ErrConnectionLost
+	// and the active subscriptions are opened again on the new link, so
+	// that their notifications keep flowing.
+	wsTransport struct {
+		dial   func(ctx context.Context) (*websocket.Conn, error) // dial dials a new link; nil if the connection cannot reconnect.
+		logger *slog.Logger
+		opts   wsOptions
+		nextID atomic.Int64  // nextID is the id of the last request.
+		quit   chan struct{} // quit is closed once the connection is closing.
+
+		mu      sync.Mutex
+		link    *link                    // link is the current link; nil while reconnecting.
+		pending map[int]*pendingCall     // pending are the calls awaiting a response, by request id.
+		subs    map[string]*subscription // subs are the subscriptions of the current link, by subscription id.
+		resume  []*subscription          // resume are the subscriptions to open again once reconnected.
+		closing bool                     // closing is true once close was called.
+		err     error                    // err is why the connection closed for good.
+		done    chan struct{}            // done is closed once the connection closed for good.
+	}
+
+	// link is a websocket connection of a wsTransport.
+	//
+	// A single goroutine reads the link and dispatches every message
+	// without blocking: responses go to the pending call of their id and
+	// notifications to the queue of their subscription. Another goroutine
+	// is the link's only writer, as required by websocket.Conn; it writes
+	// queued control messages before any other.
+	link struct {
+		ws       *websocket.Conn
+		writes   chan *writeOp // writes is the queue of the writer.
+		controls chan *writeOp // controls is the priority queue of the writer.
+		down     chan struct{} // down is closed once the link is lost.
+		silence  time.Duration // silence is how long the link can stay silent before it is lost.
+	}
+
+	// wsOptions are the options of the websocket transport.
+	wsOptions struct {
+		writeTimeout time.Duration // writeTimeout bounds every write.
+		reconnect    RetryPolicy   // reconnect is the policy for redialing a lost connection.
+		pingInterval time.Duration // pingInterval is the interval between pings; zero disables them.
+		pongTimeout  time.Duration // pongTimeout is how long a ping can go unanswered.
+	}
+
+	// writeOp is a message queued for writing.
+	writeOp struct {
+		ctx  context.Context // ctx is the context of the call; the write is skipped once it is done.
+		data []byte
+		err  chan error // err receives the result of the write.
+	}
+
+	// pendingCall is a call awaiting its response.
+	pendingCall struct {
+		method    Method          // method of the call.
+		params    []any           // params of the call.
+		control   bool            // control is true if the call is written ahead of the others.
+		subscribe bool            // subscribe is true if the response opens a subscription.
+		resume    *subscription   // resume is the subscription opened again by the call, if any.
+		ch        chan callResult // ch receives the call's result; buffered so dispatching never blocks.
+	}
+
+	// callResult is the result of a call.
+	callResult struct {
+		msg *Message      // msg is the response.
+		sub *subscription // sub is the subscription opened by the call, if any.
+		err error         // err is the error of the call.
+	}
+
+	// subscription is the queue of a subscription's notifications.
+	//
+	// The queue is unbounded so that a slow subscriber never stalls the
+	// connection's other calls and subscriptions.
+	subscription struct {
+		conn   *wsTransport // conn is the transport of the subscription.
+		method Method       // method opening the subscription.
+		params []any        // params of the method.
+
+		mu    sync.Mutex
+		id    string        // id of the subscription; it changes when resumed.
+		queue []*Message    // queue are the undelivered notifications, oldest first.
+		ready chan struct{} // ready is signaled when the queue or err changes.
+		err   error         // err is why the subscription ended.
+	}
+)
+
+const (
+	defaultWriteTimeout = 10 * time.Second
+	defaultPingInterval = 10 * time.Second
+	defaultPongTimeout  = 10 * time.Second
+	writeQueueSize      = 64
+	redialTimeout       = 10 * time.Second
+)
+
+var (
+	errConnClosed   = errors.New("e2b: sandbox connection closed")
+	errUnsubscribed = errors.New("e2b: unsubscribed")
+
+	defaultReconnectPolicy = RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+)
+
+// DialWebsocket dials the sandbox's envd websocket, the default transport.
+//
+// The transport follows the sandbox's WithWriteTimeout, WithReconnectPolicy
+// and WithHeartbeat options. Transports passed to WithTransport can wrap it,
+// for instance to record the traffic.
+func DialWebsocket(ctx context.Context, s *Sandbox) (Transport, error) {
+	ws, err := s.dialWS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newWSTransport(ws, s.dialWS, s.logger, s.wsOpts), nil
+}
+
+// newWSTransport returns a transport over the websocket and starts its
+// reader and writer.
+//
+// With a dial function and a reconnect policy, a lost websocket is
+// redialed.
+func newWSTransport(
+	ws *websocket.Conn,
+	dial func(context.Context) (*websocket.Conn, error),
+	logger *slog.Logger,
+	opts wsOptions,
+) *wsTransport {
+	if opts.writeTimeout <= 0 {
+		opts.writeTimeout = defaultWriteTimeout
+	}
+	if opts.pingInterval > 0 && opts.pongTimeout <= 0 {
+		opts.pongTimeout = opts.pingInterval
+	}
+	c := &wsTransport{
+		dial:    dial,
+		logger:  logger,
+		opts:    opts,
+		quit:    make(chan struct{}),
+		pending: map[int]*pendingCall{},
+		subs:    map[string]*subscription{},
+		done:    make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.link = c.attach(ws)
+	c.mu.Unlock()
+	return c
+}
+
+// Call calls a method and returns its response.
+//
+// Unsubscriptions are written ahead of the calls queued for writing.
+func (c *wsTransport) Call(ctx context.Context, method Method, params []any) (*Message, error) {
+	res, err := c.roundTrip(ctx, &pendingCall{method: method, params: params, control: method == processUnsubscribe})
+	return res.msg, err
+}
+
+// Subscribe calls a method opening a subscription and returns it.
+//
+// The subscription is registered as its response is dispatched, so that
+// no notification following the response is missed.
+func (c *wsTransport) Subscribe(ctx context.Context, method Method, params []any) (Subscription, error) {
+	res, err := c.roundTrip(ctx, &pendingCall{method: method, params: params, subscribe: true})
+	if err != nil {
+		return nil, err
+	}
+	return res.sub, nil
+}
+
+// unsubscribe stops dispatching the subscription's notifications; those
+// still queued are delivered.
+func (c *wsTransport) unsubscribe(sub *subscription) {
+	c.mu.Lock()
+	if id := sub.ID(); c.subs[id] == sub {
+		delete(c.subs, id)
+	}
+	c.mu.Unlock()
+	sub.end(errUnsubscribed)
+}
+
+// Close closes the transport for good and waits for it to shut down.
+func (c *wsTransport) Close() error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		<-c.done
+		return nil
+	}
+	c.closing = true
+	close(c.quit)
+	l := c.link
+	c.mu.Unlock()
+
+	var err error
+	if l != nil {
+		// The reader shuts the connection down on the error.
+		err = l.ws.Close()
+	}
+	<-c.done
+	return err
+}
+
+// roundTrip sends the call's request and waits for its response.
+func (c *wsTransport) roundTrip(ctx context.Context, call *pendingCall) (callResult, error) {
+	call.ch = make(chan callResult, 1)
+	id := int(c.nextID.Add(1))
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return callResult{}, c.err
+	}
+	l := c.link
+	if l == nil {
+		c.mu.Unlock()
+		return callResult{}, fmt.Errorf("%w: reconnecting", ErrConnectionLost)
+	}
+	c.pending[id] = call
+	c.mu.Unlock()
+
+	queue := l.writes
+	if call.control {
+		queue = l.controls
+	}
+	c.logger.Debug("request", "method", call.method, "id", id, "params", call.params)
+	err := c.enqueue(ctx, l, queue, Request{JSONRPC: rpc, Method: call.method, ID: id, Params: call.params})
+	if err != nil {
+		c.forget(id)
+		return callResult{}, fmt.Errorf("writing %s request failed (%d): %w", call.method, id, err)
+	}
+	select {
+	case res := <-call.ch:
+		return res, res.err
+	case <-ctx.Done():
+		if !c.forget(id) {
+			// The response was dispatched meanwhile; nobody will read
+			// the subscription it opened.
+			if res := <-call.ch; res.sub != nil {
+				c.unsubscribe(res.sub)
+			}
+		}
+		return callResult{}, ctx.Err()
+	}
+}
+
+// Done returns a channel closed once the transport is closed or could not
+// be reconnected.
+func (c *wsTransport) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the transport is done, or nil if it is not.
+func (c *wsTransport) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// forget drops a pending call and reports whether it was still pending.
+func (c *wsTransport) forget(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// enqueue queues the request for writing on the link and waits for it to
+// be written.
+func (c *wsTransport) enqueue(ctx context.Context, l *link, queue chan *writeOp, req Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	op := &writeOp{ctx: ctx, data: data, err: make(chan error, 1)}
+	select {
+	case queue <- op:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.down:
+		return ErrConnectionLost
+	}
+	select {
+	case err := <-op.err:
+		return err
+	case <-l.down:
+		select {
+		case err := <-op.err:
+			// The write failing brought the link down.
+			return err
+		default:
+			return ErrConnectionLost
+		}
+	}
+}
+
+// attach starts the reader, writer and heartbeat of a new link over the
+// websocket.
+func (c *wsTransport) attach(ws *websocket.Conn) *link {
+	l := &link{
+		ws:       ws,
+		writes:   make(chan *writeOp, writeQueueSize),
+		controls: make(chan *writeOp, writeQueueSize),
+		down:     make(chan struct{}),
+	}
+	if c.opts.pingInterval > 0 {
+		l.silence = c.opts.pingInterval + c.opts.pongTimeout
+		l.alive()
+		ws.SetPongHandler(func(string) error {
+			l.alive()
+			return nil
+		})
+		go c.heartbeat(l)
+	}
+	go c.read(l)
+	go c.write(l)
+	return l
+}
+
+// heartbeat pings the link every ping interval until it is lost.
+//
+// A link whose pings go unanswered for the pong timeout, such as a
+// half-open tcp connection, fails its reader on the read deadline.
+func (c *wsTransport) heartbeat(l *link) {
+	ticker := time.NewTicker(c.opts.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.down:
+			return
+		case <-ticker.C:
+		}
+		err := l.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.opts.writeTimeout))
+		if err != nil {
+			_ = l.ws.Close()
+			return
+		}
+	}
+}
+
+// write writes the link's queued messages, control messages first, until
+// the link is lost.
+//
+// Every write is bounded by the write timeout. A message whose context is
+// done by the time it is dequeued is skipped, as nobody awaits its
+// response.
+func (c *wsTransport) write(l *link) {
+	for {
+		var op *writeOp
+		select {
+		case op = <-l.controls:
+		default:
+			select {
+			case op = <-l.controls:
+			case op = <-l.writes:
+			case <-l.down:
+				return
+			}
+		}
+		if err := op.ctx.Err(); err != nil {
+			op.err <- err
+			continue
+		}
+		_ = l.ws.SetWriteDeadline(time.Now().Add(c.opts.writeTimeout))
+		err := l.ws.WriteMessage(websocket.TextMessage, op.data)
+		op.err <- err
+		if err != nil {
+			// A failed write may have left a partial frame behind; the
+			// link cannot be used anymore.
+			_ = l.ws.Close()
+		}
+	}
+}
+
+// read reads and dispatches the link's messages until it fails.
+func (c *wsTransport) read(l *link) {
+	var err error
+	defer func() {
+		c.lost(l, err)
+	}()
+	for {
+		var data []byte
+		_, data, err = l.ws.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			err = fmt.Errorf("no heartbeat for %s: %w", l.silence, err)
+		}
+		if err != nil {
+			return
+		}
+		if l.silence > 0 {
+			l.alive()
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.logger.Warn("dropping malformed sandbox message", "error", err)
+			continue
+		}
+		c.dispatch(&msg)
+	}
+}
+
+func (c *wsTransport) dispatch(msg *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case msg.ID != 0:
+		call, ok := c.pending[msg.ID]
+		if !ok {
+			// The caller gave up before the response arrived.
+			c.logger.Debug("dropping late response", "id", msg.ID)
+			return
+		}
+		delete(c.pending, msg.ID)
+		res := callResult{msg: msg}
+		if call.subscribe {
+			res.sub, res.err = c.register(call, msg)
+		}
+		call.ch <- res
+	case msg.Params != nil && msg.Params.Subscription != "":
+		sub, ok := c.subs[msg.Params.Subscription]
+		if !ok {
+			// The subscription was unsubscribed or never opened.
+			c.logger.Debug("dropping orphaned notification", "subscription", msg.Params.Subscription)
+			return
+		}
+		sub.push(msg)
+	default:
+		c.logger.Debug("dropping unexpected sandbox message")
+	}
+}
+
+// register registers the subscription opened by the call's response: the
+// one it resumes, or a new one.
+//
+// A subscription closed while being resumed is not registered again; it is
+// unsubscribed from envd instead.
+func (c *wsTransport) register(call *pendingCall, msg *Message) (*subscription, error) {
+	var id string
+	_ = json.Unmarshal(msg.Result, &id)
+	if id == "" {
+		return nil, fmt.Errorf("subscription failed: %s", msg.Error)
+	}
+	sub := call.resume
+	if sub != nil && sub.ended() {
+		go c.discard(call.method, id)
+		return sub, nil
+	}
+	if sub == nil {
+		sub = &subscription{
+			conn:   c,
+			method: call.method,
+			params: call.params,
+			ready:  make(chan struct{}, 1),
+		}
+	}
+	sub.mu.Lock()
+	sub.id = id
+	sub.mu.Unlock()
+	c.subs[id] = sub
+	return sub, nil
+}
+
+// discard unsubscribes from envd the subscription opened by the method
+// that nobody reads, writing the unsubscription on the control lane.
+func (c *wsTransport) discard(method Method, id string) {
+	namespace, _, _ := strings.Cut(string(method), "_")
+	ctx, cancel := context.WithTimeout(context.Background(), redialTimeout)
+	defer cancel()
+	_, err := c.roundTrip(ctx, &pendingCall{
+		method:  Method(namespace + "_unsubscribe"),
+		params:  []any{id},
+		control: true,
+	})
+	if err != nil {
+		c.logger.Debug("failed to unsubscribe discarded subscription", "method", method, "id", id, "error", err)
+	}
+}
+
+// lost handles the loss of a link: its calls in flight fail and its
+// subscriptions are set aside to resume, then the connection reconnects
+// unless it is closing or cannot reconnect.
+func (c *wsTransport) lost(l *link, cause error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.link = nil
+	close(l.down)
+	for _, sub := range c.subs {
+		c.resume = append(c.resume, sub)
+	}
+	clear(c.subs)
+	if c.closing {
+		c.shutdownLocked(errConnClosed)
+		return
+	}
+	err := fmt.Errorf("%w: %w", ErrConnectionLost, cause)
+	if c.dial == nil || c.opts.reconnect.MaxAttempts <= 0 {
+		c.shutdownLocked(err)
+		return
+	}
+	for id, call := range c.pending {
+		call.ch <- callResult{err: err}
+		delete(c.pending, id)
+	}
+	c.logger.Warn("sandbox connection lost, reconnecting", "error", cause)
+	go c.reconnect(cause)
+}
+
+// reconnect redials the connection with backoff, then resumes the
+// subscriptions.
+func (c *wsTransport) reconnect(cause error) {
+	err := cause
+	for attempt := 1; attempt <= c.opts.reconnect.MaxAttempts; attempt++ {
+		select {
+		case <-c.quit:
+			c.shutdown(errConnClosed)
+			return
+		case <-time.After(c.opts.reconnect.backoff(attempt, err)):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), redialTimeout)
+		var ws *websocket.Conn
+		ws, err = c.dial(ctx)
+		cancel()
+		if err != nil {
+			c.logger.Warn("failed to reconnect to sandbox", "attempt", attempt, "error", err)
+			continue
+		}
+		c.mu.Lock()
+		if c.closing {
+			c.mu.Unlock()
+			_ = ws.Close()
+			c.shutdown(errConnClosed)
+			return
+		}
+		c.link = c.attach(ws)
+		c.mu.Unlock()
+		c.logger.Info("reconnected to sandbox", "attempt", attempt)
+		c.replay()
+		return
+	}
+	c.shutdown(fmt.Errorf("%w: %w", ErrConnectionLost, err))
+}
+
+// replay opens the subscriptions to resume again on the current link.
+func (c *wsTransport) replay() {
+	for {
+		c.mu.Lock()
+		if len(c.resume) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		sub := c.resume[0]
+		c.resume = c.resume[1:]
+		c.mu.Unlock()
+		if sub.ended() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), redialTimeout)
+		_, err := c.roundTrip(ctx, &pendingCall{
+			method:    sub.method,
+			params:    sub.params,
+			subscribe: true,
+			resume:    sub,
+		})
+		cancel()
+		if errors.Is(err, ErrConnectionLost) {
+			// Lost again: the next reconnection resumes it.
+			c.mu.Lock()
+			c.resume = append(c.resume, sub)
+			c.mu.Unlock()
+			return
+		}
+		if err != nil {
+			c.logger.Warn("failed to resume sandbox subscription", "method", sub.method, "error", err)
+			sub.end(err)
+		}
+	}
+}
+
+func (c *wsTransport) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shutdownLocked(err)
+}
+
+// shutdownLocked closes the connection for good: its calls in flight fail
+// and its subscriptions end.
+func (c *wsTransport) shutdownLocked(err error) {
+	c.err = err
+	for id, call := range c.pending {
+		call.ch <- callResult{err: err}
+		delete(c.pending, id)
+	}
+	for id, sub := range c.subs {
+		sub.end(err)
+		delete(c.subs, id)
+	}
+	for _, sub := range c.resume {
+		sub.end(err)
+	}
+	c.resume = nil
+	close(c.done)
+}
+
+// alive extends the link's read deadline after a sign of life.
+func (l *link) alive() {
+	_ = l.ws.SetReadDeadline(time.Now().Add(l.silence))
+}
+
+// ID returns the current id of the subscription; it changes when resumed.
+func (s *subscription) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+func (s *subscription) push(msg *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.queue = append(s.queue, msg)
+	s.signal()
+}
+
+// Next returns the next notification, waiting for it until the
+// subscription ends or the context is canceled.
+func (s *subscription) Next(ctx context.Context) (*Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			msg := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ended reports whether the subscription ended.
+func (s *subscription) ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+// Close stops dispatching the subscription's notifications; those still
+// queued are delivered.
+func (s *subscription) Close() {
+	s.conn.unsubscribe(s)
+}
+
+// end ends the subscription with the error once its queue is drained.
+func (s *subscription) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.signal()
+}
+
+func (s *subscription) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}